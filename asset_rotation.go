@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type localAsset struct {
+	video     database.Video
+	assetPath string
+	size      int64
+	modTime   time.Time
+}
+
+func (cfg *apiConfig) runAssetRotation(ctx context.Context) {
+	ticker := time.NewTicker(cfg.assetRotationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.rotateAssets(ctx); err != nil {
+				log.Printf("Asset rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+func (cfg *apiConfig) rotateAssets(ctx context.Context) error {
+	prefix := cfg.getAssetURL("")
+	videos, err := cfg.db.GetVideosWithThumbnailPrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	assets := []localAsset{}
+	var totalSize int64
+	for _, video := range videos {
+		assetPath := strings.TrimPrefix(*video.ThumbnailURL, prefix)
+		info, err := os.Stat(cfg.getAssetDiskPath(assetPath))
+		if err != nil {
+			continue
+		}
+		assets = append(assets, localAsset{video: video, assetPath: assetPath, size: info.Size(), modTime: info.ModTime()})
+		totalSize += info.Size()
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].modTime.Before(assets[j].modTime)
+	})
+
+	for _, asset := range assets {
+		tooOld := cfg.assetRotationMaxAge > 0 && time.Since(asset.modTime) > cfg.assetRotationMaxAge
+		overBudget := cfg.assetRotationMaxBytes > 0 && totalSize > cfg.assetRotationMaxBytes
+		if !tooOld && !overBudget {
+			continue
+		}
+		// A video that's being processed is picked up on the next run.
+		if !cfg.processingLocks.tryLock(asset.video.ID) {
+			continue
+		}
+		err := cfg.rotateAsset(ctx, asset)
+		cfg.processingLocks.unlock(asset.video.ID)
+		if err != nil {
+			log.Printf("Couldn't rotate asset %s: %v", asset.assetPath, err)
+			continue
+		}
+		totalSize -= asset.size
+	}
+	return nil
+}
+
+func (cfg *apiConfig) rotateAsset(ctx context.Context, asset localAsset) error {
+	diskPath := cfg.getAssetDiskPath(asset.assetPath)
+	file, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Local paths carry the tenant segment in front; S3 keys put it before
+	// thumbnails/, the same layout storeThumbnail uses.
+	userID := asset.video.UserID
+	assetKey := strings.TrimPrefix(asset.assetPath, tenantSegment(userID)+"/")
+	key := cfg.userKey(userID, path.Join("thumbnails", assetKey))
+	err = cfg.uploadToS3(ctx, cfg.s3Bucket, key, file, mime.TypeByExtension(filepath.Ext(diskPath)))
+	if err != nil {
+		return err
+	}
+
+	replaced, err := cfg.db.ReplaceVideoThumbnailURL(asset.video.ID, *asset.video.ThumbnailURL, cfg.getObjectURL(key))
+	if err != nil || !replaced {
+		// The thumbnail changed since it was listed, so the copy isn't
+		// referenced and the local file belongs to whoever changed it.
+		if delErr := cfg.deleteObject(context.Background(), cfg.s3Bucket, key); delErr != nil {
+			log.Printf("Couldn't clean up rotated copy %s: %v", key, delErr)
+		}
+		return err
+	}
+	return os.Remove(diskPath)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// createLocalThumbnail writes a thumbnail under the user's local asset path,
+// last modified age ago, and points the video at it.
+func createLocalThumbnail(t *testing.T, cfg *apiConfig, video database.Video, name string, age time.Duration) database.Video {
+	t.Helper()
+	assetPath := cfg.userKey(video.UserID, name)
+	diskPath := cfg.getAssetDiskPath(assetPath)
+	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(diskPath, []byte("thumbnail"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(diskPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.getAssetURL(assetPath)
+	video.ThumbnailURL = &thumbnailURL
+	video, err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestRotateAssets(t *testing.T) {
+	tests := []struct {
+		name            string
+		tenantIsolation bool
+	}{
+		{"flat keys", false},
+		{"tenant isolation", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{}}
+			cfg := newFakeS3Config(t, fake)
+			cfg.tenantIsolation = tt.tenantIsolation
+			cfg.assetRotationMaxAge = time.Hour
+			userID, _ := createTestUser(t, cfg, "user@example.com")
+
+			old := createLocalThumbnail(t, cfg, createTestVideo(t, cfg, userID, database.VisibilityPublic), "old.png", 2*time.Hour)
+			fresh := createLocalThumbnail(t, cfg, createTestVideo(t, cfg, userID, database.VisibilityPublic), "fresh.png", time.Minute)
+
+			if err := cfg.rotateAssets(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			wantKey := cfg.userKey(userID, "thumbnails/old.png")
+			if want := []string{"/tubely-test/" + wantKey}; !slices.Equal(fake.puts, want) {
+				t.Errorf("puts = %v, want %v", fake.puts, want)
+			}
+			rotated, err := cfg.db.GetVideo(old.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := cfg.getObjectURL(wantKey); rotated.ThumbnailURL == nil || *rotated.ThumbnailURL != want {
+				t.Errorf("rotated thumbnail URL = %v, want %s", rotated.ThumbnailURL, want)
+			}
+			if _, err := os.Stat(cfg.getAssetDiskPath(cfg.userKey(userID, "old.png"))); !os.IsNotExist(err) {
+				t.Errorf("rotated file is still on disk: %v", err)
+			}
+
+			kept, err := cfg.db.GetVideo(fresh.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *kept.ThumbnailURL != *fresh.ThumbnailURL {
+				t.Errorf("fresh thumbnail was rotated to %s", *kept.ThumbnailURL)
+			}
+		})
+	}
+}
+
+func TestRotateAssetKeepsConcurrentChanges(t *testing.T) {
+	fake := &fakeS3{existing: map[string]bool{}}
+	cfg := newFakeS3Config(t, fake)
+	userID, _ := createTestUser(t, cfg, "user@example.com")
+	video := createLocalThumbnail(t, cfg, createTestVideo(t, cfg, userID, database.VisibilityPublic), "old.png", 2*time.Hour)
+	listed := localAsset{video: video, assetPath: "old.png"}
+
+	// An upload lands between listing the asset and rotating it.
+	videoURL := cfg.getObjectURL("landscape/new.mp4")
+	newThumbnailURL := cfg.getObjectURL("thumbnails/new.png")
+	current := video
+	current.VideoURL = &videoURL
+	current.ThumbnailURL = &newThumbnailURL
+	current.ProcessingStatus = database.ProcessingStatusReady
+	if _, err := cfg.db.UpdateVideo(current); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.rotateAsset(context.Background(), listed); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.VideoURL == nil || *updated.VideoURL != videoURL || *updated.ThumbnailURL != newThumbnailURL {
+		t.Errorf("rotation overwrote the upload: video %v, thumbnail %v", updated.VideoURL, updated.ThumbnailURL)
+	}
+	if want := []string{"/tubely-test/thumbnails/old.png"}; !slices.Equal(fake.deletes, want) {
+		t.Errorf("deletes = %v, want the unreferenced copy %v", fake.deletes, want)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -16,30 +22,177 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	return nil
 }
 
-func getAssetPath(mediaType string) string {
+// randReader is the source of asset IDs; tests swap it for a failing reader.
+var randReader io.Reader = rand.Reader
+
+func randomAssetID() (string, error) {
 	base := make([]byte, 32)
-	_, err := rand.Read(base)
+	_, err := io.ReadFull(randReader, base)
 	if err != nil {
-		panic("failed to generate random bytes")
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(base), nil
+}
+
+func (cfg apiConfig) aspectRatioPrefix(aspectRatio string) string {
+	switch aspectRatio {
+	case "16:9":
+		return cfg.landscapePrefix
+	case "9:16":
+		return cfg.portraitPrefix
+	case "4:3":
+		return cfg.standardPrefix
+	case "3:4":
+		return cfg.standardPortraitPrefix
+	case "1:1":
+		return cfg.squarePrefix
+	case "21:9":
+		return cfg.ultrawidePrefix
+	default:
+		return cfg.otherPrefix
 	}
-	id := base64.RawURLEncoding.EncodeToString(base)
+}
 
-	ext := mediaTypeToExt(mediaType)
-	return fmt.Sprintf("%s%s", id, ext)
+func validateKeyPrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("prefix must not be empty")
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("prefix %q is not a clean relative path", prefix)
+		}
+		for _, r := range segment {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+				return fmt.Errorf("prefix %q contains invalid character %q", prefix, r)
+			}
+		}
+	}
+	return nil
 }
 
 func (cfg apiConfig) getObjectURL(key string) string {
-	return fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
+	if cfg.devMode {
+		return cfg.getAssetURL(key)
+	}
+	if cfg.s3CfDistribution != "" {
+		return fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
+	}
+	return cfg.s3ObjectURL(cfg.s3Bucket, key)
+}
+
+// s3ObjectURL addresses an object directly on the S3 endpoint, path-style
+// (endpoint/bucket/key) or virtual-hosted (bucket.endpoint/key).
+func (cfg apiConfig) s3ObjectURL(bucket, key string) string {
+	endpoint := cfg.s3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.s3Region)
+	}
+	if cfg.s3UsePathStyle {
+		return fmt.Sprintf("%s/%s/%s", endpoint, bucket, key)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Sprintf("%s/%s/%s", endpoint, bucket, key)
+	}
+	u.Host = bucket + "." + u.Host
+	return fmt.Sprintf("%s/%s", u.String(), key)
+}
+
+func (cfg apiConfig) objectKeyFromURL(objectURL string) (string, bool) {
+	prefix := cfg.s3ObjectURL(cfg.s3Bucket, "")
+	if cfg.s3CfDistribution != "" {
+		prefix = cfg.s3CfDistribution + "/"
+	}
+	key, ok := strings.CutPrefix(objectURL, prefix)
+	return key, ok && key != ""
+}
+
+func (cfg apiConfig) videoObject(video database.Video) (bucket, key string, ok bool) {
+	if video.VideoURL == nil {
+		return "", "", false
+	}
+	return cfg.storedObject(*video.VideoURL)
+}
+
+// deleteVideoObjects removes the video's file, renditions and contact sheet
+// from storage. Objects that are already gone count as deleted.
+func (cfg *apiConfig) deleteVideoObjects(ctx context.Context, video database.Video) error {
+	storedURLs := []string{}
+	for _, storedURL := range video.Renditions {
+		storedURLs = append(storedURLs, storedURL)
+	}
+	if video.VideoURL != nil {
+		storedURLs = append(storedURLs, *video.VideoURL)
+	}
+	if video.ContactSheetURL != nil {
+		storedURLs = append(storedURLs, *video.ContactSheetURL)
+	}
+	for _, storedURL := range storedURLs {
+		bucket, key, ok := cfg.storedObject(storedURL)
+		if !ok {
+			continue
+		}
+		if err := cfg.deleteObject(ctx, bucket, key); err != nil && !isNotFound(err) {
+			return fmt.Errorf("could not delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// storedObject returns the bucket and key a stored video URL points at.
+func (cfg apiConfig) storedObject(storedURL string) (bucket, key string, ok bool) {
+	if bucket, key, ok := strings.Cut(storedURL, ","); ok {
+		return bucket, key, true
+	}
+	if key, ok := strings.CutPrefix(storedURL, cfg.getAssetURL("")); ok && key != "" {
+		return cfg.s3Bucket, key, true
+	}
+	key, ok = cfg.objectKeyFromURL(storedURL)
+	return cfg.s3Bucket, key, ok
 }
 
 func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
 	return filepath.Join(cfg.assetsRoot, assetPath)
 }
 
+// httpsURL upgrades an http URL when FORCE_HTTPS_URLS is set. URLs are stored
+// as generated, so this is applied when they're handed out.
+func (cfg apiConfig) httpsURL(assetURL string) string {
+	if !cfg.forceHTTPSURLs {
+		return assetURL
+	}
+	if rest, ok := strings.CutPrefix(assetURL, "http://"); ok {
+		return "https://" + rest
+	}
+	return assetURL
+}
+
 func (cfg apiConfig) getAssetURL(assetPath string) string {
 	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
 }
 
+func (cfg apiConfig) removeLocalAsset(assetURL string) error {
+	assetPath, ok := strings.CutPrefix(assetURL, cfg.getAssetURL(""))
+	if !ok || assetPath == "" {
+		return nil
+	}
+	err := os.Remove(cfg.getAssetDiskPath(assetPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (cfg *apiConfig) removeAsset(ctx context.Context, assetURL string) error {
+	if strings.HasPrefix(assetURL, cfg.getAssetURL("")) {
+		return cfg.removeLocalAsset(assetURL)
+	}
+	if bucket, key, ok := cfg.storedObject(assetURL); ok {
+		return cfg.deleteObject(ctx, bucket, key)
+	}
+	return nil
+}
+
 func mediaTypeToExt(mediaType string) string {
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestAspectRatioPrefix(t *testing.T) {
+	cfg := apiConfig{
+		landscapePrefix:        "wide",
+		portraitPrefix:         "tall",
+		standardPrefix:         "four-three",
+		standardPortraitPrefix: "three-four",
+		squarePrefix:           "sq",
+		ultrawidePrefix:        "cinema",
+		otherPrefix:            "misc",
+	}
+	tests := []struct {
+		aspectRatio string
+		want        string
+	}{
+		{"16:9", "wide"},
+		{"9:16", "tall"},
+		{"4:3", "four-three"},
+		{"3:4", "three-four"},
+		{"1:1", "sq"},
+		{"21:9", "cinema"},
+		{"other", "misc"},
+	}
+	for _, tt := range tests {
+		if got := cfg.aspectRatioPrefix(tt.aspectRatio); got != tt.want {
+			t.Errorf("aspectRatioPrefix(%q) = %q, want %q", tt.aspectRatio, got, tt.want)
+		}
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  apiConfig
+		want string
+	}{
+		{
+			name: "aws virtual-hosted",
+			cfg:  apiConfig{s3Region: "us-east-2"},
+			want: "https://bucket.s3.us-east-2.amazonaws.com/key",
+		},
+		{
+			name: "custom endpoint path-style",
+			cfg:  apiConfig{s3Endpoint: "http://localhost:9000", s3UsePathStyle: true},
+			want: "http://localhost:9000/bucket/key",
+		},
+		{
+			name: "custom endpoint virtual-hosted",
+			cfg:  apiConfig{s3Endpoint: "https://nyc3.digitaloceanspaces.com"},
+			want: "https://bucket.nyc3.digitaloceanspaces.com/key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.s3ObjectURL("bucket", "key"); got != tt.want {
+				t.Errorf("s3ObjectURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
 
 func noCacheMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -8,3 +14,49 @@ func noCacheMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// videoETag identifies one representation of a video's metadata. The
+// variant names anything that changes the body for the same row, such as
+// the owner-only object key.
+func videoETag(video database.Video, variant string) string {
+	etag := fmt.Sprintf("%s-%d-%s", video.ID, video.UpdatedAt.UnixNano(), video.Visibility)
+	if variant != "" {
+		etag += "-" + variant
+	}
+	return fmt.Sprintf(`W/"%s"`, etag)
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setVideoCacheHeaders reports whether the client's cached copy is still
+// current, in which case a 304 has already been written.
+func (cfg *apiConfig) setVideoCacheHeaders(w http.ResponseWriter, r *http.Request, video database.Video, variant string) bool {
+	// Private videos and owner-only fields depend on who is asking.
+	w.Header().Add("Vary", "Authorization")
+	if cfg.presignVideoURLs {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+
+	etag := videoETag(video, variant)
+	w.Header().Set("ETag", etag)
+	if video.VideoURL == nil || cfg.metadataCacheMaxAge == 0 {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", cfg.metadataCacheMaxAge))
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
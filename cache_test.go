@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func getVideoMeta(cfg *apiConfig, video database.Video, query, token, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+query, nil)
+	req.SetPathValue("videoID", video.ID.String())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	cfg.handlerVideoGet(w, req)
+	return w
+}
+
+func TestVideoGetConditionalRequest(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.metadataCacheMaxAge = 60
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	first := getVideoMeta(cfg, video, "", token, "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", first.Code)
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+	if vary := first.Header().Get("Vary"); vary != "Authorization" {
+		t.Errorf("Vary = %q, want Authorization", vary)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		token string
+		want  int
+	}{
+		{"unchanged", "", token, http.StatusNotModified},
+		{"anonymous same representation", "", "", http.StatusNotModified},
+		{"owner asks for the key", "?includeKey=true", token, http.StatusOK},
+		{"non-owner asks for the key", "?includeKey=true", "", http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getVideoMeta(cfg, video, tt.query, tt.token, etag)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestVideoGetETagChangesWithVisibility(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	etag := getVideoMeta(cfg, video, "", token, "").Header().Get("ETag")
+
+	video.Visibility = database.VisibilityUnlisted
+	video, err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := getVideoMeta(cfg, video, "", token, etag); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after a visibility change, got %d", w.Code)
+	}
+}
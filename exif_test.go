@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// exifSegment builds an APP1 segment whose first IFD holds an Orientation
+// tag and a GPS IFD pointer to an empty GPS IFD.
+func exifSegment(order binary.AppendByteOrder, orientation int) []byte {
+	tiff := []byte("MM\x00\x2a")
+	if order == binary.LittleEndian {
+		tiff = []byte("II\x2a\x00")
+	}
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint16(tiff, exifOrientationTag)
+	tiff = order.AppendUint16(tiff, 3) // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, uint16(orientation))
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint16(tiff, 0x8825) // GPS IFD pointer
+	tiff = order.AppendUint16(tiff, 4)      // LONG
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint32(tiff, 8+2+2*12+4)
+	tiff = order.AppendUint32(tiff, 0) // no next IFD
+	tiff = order.AppendUint16(tiff, 0) // empty GPS IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEGWithEXIF encodes img as a JPEG with an EXIF segment right after
+// the start-of-image marker.
+func testJPEGWithEXIF(t *testing.T, img image.Image, order binary.AppendByteOrder, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), exifSegment(order, orientation)...), data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", testJPEGWithEXIF(t, img, binary.BigEndian, 6), 6},
+		{"little endian", testJPEGWithEXIF(t, img, binary.LittleEndian, 8), 8},
+		{"out of range", testJPEGWithEXIF(t, img, binary.BigEndian, 9), 1},
+		{"no EXIF", plain.Bytes(), 1},
+		{"not a JPEG", testPNG(t, 8, 8), 1},
+		{"truncated", testJPEGWithEXIF(t, img, binary.BigEndian, 6)[:12], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(bytes.NewReader(tt.data)); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 3x2 image with only its top-left pixel white.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, color.White)
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		white         image.Point
+	}{
+		{1, 3, 2, image.Pt(0, 0)},
+		{2, 3, 2, image.Pt(2, 0)},
+		{3, 3, 2, image.Pt(2, 1)},
+		{4, 3, 2, image.Pt(0, 1)},
+		{5, 2, 3, image.Pt(0, 0)},
+		{6, 2, 3, image.Pt(1, 0)},
+		{7, 2, 3, image.Pt(1, 2)},
+		{8, 2, 3, image.Pt(0, 2)},
+	}
+	for _, tt := range tests {
+		dst := applyOrientation(src, tt.orientation)
+		bounds := dst.Bounds()
+		if bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.orientation, bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			continue
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				r, _, _, _ := dst.At(x, y).RGBA()
+				if white := r > 0; white != (image.Pt(x, y) == tt.white) {
+					t.Errorf("orientation %d: pixel (%d,%d) white = %v", tt.orientation, x, y, white)
+				}
+			}
+		}
+	}
+}
+
+func TestThumbnailUploadAppliesOrientation(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	// Stored sideways: red on the left, blue on the right, tagged to be
+	// rotated 90 degrees clockwise, which puts red on top.
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 40 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	w := uploadThumbnail(t, cfg, video.ID, token, testJPEGWithEXIF(t, img, binary.BigEndian, 6))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(cfg.getAssetDiskPath(strings.TrimPrefix(*updated.ThumbnailURL, cfg.getAssetURL(""))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("Exif")) {
+		t.Error("stored thumbnail still has an EXIF segment")
+	}
+	upright, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := upright.Bounds(); bounds.Dx() != 40 || bounds.Dy() != 80 {
+		t.Fatalf("stored thumbnail is %dx%d, want 40x80", bounds.Dx(), bounds.Dy())
+	}
+	if r, _, b, _ := upright.At(20, 10).RGBA(); r < b {
+		t.Error("top of the stored thumbnail isn't red")
+	}
+	if r, _, b, _ := upright.At(20, 70).RGBA(); b < r {
+		t.Error("bottom of the stored thumbnail isn't blue")
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const ffmpegRetryDelay = 500 * time.Millisecond
+
+type ffmpegError struct {
+	action string
+	stderr string
+	err    error
+}
+
+func (e *ffmpegError) Error() string {
+	return fmt.Sprintf("error %s: %s, %v", e.action, e.stderr, e.err)
+}
+
+func (e *ffmpegError) Unwrap() error {
+	return e.err
+}
+
+func (cfg *apiConfig) isRecoverableFFmpegError(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, signature := range cfg.ffmpegRetryableErrors {
+		if strings.Contains(stderr, strings.ToLower(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) runFFmpegCommand(ctx context.Context, action string, stdout *bytes.Buffer, name string, args ...string) error {
+	var err error
+	for attempt := 1; attempt <= cfg.ffmpegMaxAttempts; attempt++ {
+		if stdout != nil {
+			stdout.Reset()
+		}
+		cmd := exec.CommandContext(ctx, name, args...)
+		var stderr bytes.Buffer
+		if stdout != nil {
+			cmd.Stdout = stdout
+		}
+		cmd.Stderr = &stderr
+
+		runErr := cmd.Run()
+		if runErr == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s: %w", action, ctxErr)
+		}
+		err = &ffmpegError{action: action, stderr: stderr.String(), err: runErr}
+		if !cfg.isRecoverableFFmpegError(stderr.String()) {
+			return err
+		}
+		if attempt < cfg.ffmpegMaxAttempts {
+			if budgetErr := spendRetry(ctx); budgetErr != nil {
+				return fmt.Errorf("%w: %w", budgetErr, err)
+			}
+			log.Printf("Retrying %s after recoverable error (attempt %d of %d): %v", action, attempt, cfg.ffmpegMaxAttempts, runErr)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * ffmpegRetryDelay):
+			}
+		}
+	}
+	return err
+}
+
+type ffprobeStream struct {
+	CodecType          string `json:"codec_type"`
+	CodecName          string `json:"codec_name"`
+	Profile            string `json:"profile"`
+	Level              int    `json:"level"`
+	HasBFrames         int    `json:"has_b_frames"`
+	CodecTagString     string `json:"codec_tag_string"`
+	Width              int    `json:"width"`
+	Height             int    `json:"height"`
+	PixFmt             string `json:"pix_fmt"`
+	ColorSpace         string `json:"color_space"`
+	ColorTransfer      string `json:"color_transfer"`
+	ColorPrimaries     string `json:"color_primaries"`
+	SampleAspectRatio  string `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string `json:"display_aspect_ratio"`
+	Duration           string `json:"duration"`
+	Tags               struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type colorInfo struct {
+	PixFmt         *string
+	ColorSpace     *string
+	ColorTransfer  *string
+	ColorPrimaries *string
+}
+
+type ffprobeChapter struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Tags      struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+type ffprobeOutput struct {
+	Streams  []ffprobeStream  `json:"streams"`
+	Chapters []ffprobeChapter `json:"chapters"`
+	Format   struct {
+		Duration string `json:"duration"`
+		Size     string `json:"size"`
+		BitRate  string `json:"bit_rate"`
+		Tags     struct {
+			CreationTime string `json:"creation_time"`
+		} `json:"tags"`
+	} `json:"format"`
+}
+
+func (cfg *apiConfig) probeVideo(ctx context.Context, filePath string) (ffprobeOutput, error) {
+	var stdout bytes.Buffer
+	err := cfg.runFFmpegCommand(ctx, "probing video", &stdout, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		"-show_chapters",
+		filePath,
+	)
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+
+	var output ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return ffprobeOutput{}, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	return output, nil
+}
+
+var errNoVideoStream = errors.New("no video stream found")
+
+// primaryStream returns the first video stream; ffprobe often lists audio first.
+func primaryStream(probe ffprobeOutput) (ffprobeStream, error) {
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" {
+			return stream, nil
+		}
+	}
+	return ffprobeStream{}, fmt.Errorf("%w (%d streams probed)", errNoVideoStream, len(probe.Streams))
+}
+
+const (
+	orientationLandscape = "landscape"
+	orientationPortrait  = "portrait"
+	orientationSquare    = "square"
+)
+
+func streamRotation(stream ffprobeStream) int {
+	rotation := 0
+	for _, sideData := range stream.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = int(sideData.Rotation)
+			break
+		}
+	}
+	if rotation == 0 && stream.Tags.Rotate != "" {
+		rotation, _ = strconv.Atoi(stream.Tags.Rotate)
+	}
+	return ((rotation % 360) + 360) % 360
+}
+
+func rotateDimensions(stream ffprobeStream, width, height int) (int, int) {
+	switch streamRotation(stream) {
+	case 90, 270:
+		return height, width
+	}
+	return width, height
+}
+
+func getVideoDimensions(probe ffprobeOutput) (int, int, error) {
+	stream, err := primaryStream(probe)
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height := rotateDimensions(stream, stream.Width, stream.Height)
+	return width, height, nil
+}
+
+func parseRatio(value string) (int, int, bool) {
+	num, den, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n <= 0 {
+		return 0, 0, false
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil || d <= 0 {
+		return 0, 0, false
+	}
+	return n, d, true
+}
+
+func getVideoDisplayDimensions(probe ffprobeOutput) (int, int, error) {
+	stream, err := primaryStream(probe)
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height := stream.Width, stream.Height
+	if n, d, ok := parseRatio(stream.DisplayAspectRatio); ok {
+		width = height * n / d
+	} else if n, d, ok := parseRatio(stream.SampleAspectRatio); ok {
+		width = width * n / d
+	}
+	width, height = rotateDimensions(stream, width, height)
+	return width, height, nil
+}
+
+func getVideoAspectRatios(probe ffprobeOutput) (sar, dar *string, err error) {
+	stream, err := primaryStream(probe)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, _, ok := parseRatio(stream.SampleAspectRatio); ok {
+		sar = &stream.SampleAspectRatio
+	}
+	if _, _, ok := parseRatio(stream.DisplayAspectRatio); ok {
+		dar = &stream.DisplayAspectRatio
+	}
+	return sar, dar, nil
+}
+
+func getVideoOrientation(width, height int) string {
+	switch {
+	case width > height:
+		return orientationLandscape
+	case height > width:
+		return orientationPortrait
+	}
+	return orientationSquare
+}
+
+func getVideoColorInfo(probe ffprobeOutput) (colorInfo, error) {
+	stream, err := primaryStream(probe)
+	if err != nil {
+		return colorInfo{}, err
+	}
+	return colorInfo{
+		PixFmt:         probeTag(stream.PixFmt),
+		ColorSpace:     probeTag(stream.ColorSpace),
+		ColorTransfer:  probeTag(stream.ColorTransfer),
+		ColorPrimaries: probeTag(stream.ColorPrimaries),
+	}, nil
+}
+
+type encodingInfo struct {
+	Profile    *string
+	Level      *string
+	HasBFrames int
+}
+
+// getVideoEncodingInfo reports the profile, level and B-frame depth, which
+// decide whether constrained devices can decode the stream.
+func getVideoEncodingInfo(probe ffprobeOutput) (encodingInfo, error) {
+	stream, err := primaryStream(probe)
+	if err != nil {
+		return encodingInfo{}, err
+	}
+	info := encodingInfo{
+		Profile:    probeTag(stream.Profile),
+		HasBFrames: stream.HasBFrames,
+	}
+	if stream.Level > 0 {
+		level := strconv.Itoa(stream.Level)
+		if stream.CodecName == "h264" {
+			// ffprobe reports H.264 level 3.1 as 31.
+			level = fmt.Sprintf("%d.%d", stream.Level/10, stream.Level%10)
+		}
+		info.Level = &level
+	}
+	return info, nil
+}
+
+func probeTag(value string) *string {
+	if value == "" || value == "unknown" {
+		return nil
+	}
+	return &value
+}
+
+type aspectRatioBucket struct {
+	Name  string
+	Ratio float64
+}
+
+var commonAspectRatios = []aspectRatioBucket{
+	{"16:9", 16.0 / 9},
+	{"9:16", 9.0 / 16},
+	{"4:3", 4.0 / 3},
+	{"3:4", 3.0 / 4},
+	{"1:1", 1},
+	{"21:9", 21.0 / 9},
+}
+
+const aspectRatioTolerance = 0.05
+
+func classifyAspectRatio(width, height int) (string, float64) {
+	if width <= 0 || height <= 0 {
+		return "other", 0
+	}
+	ratio := float64(width) / float64(height)
+	name, closest := "other", aspectRatioTolerance
+	for _, bucket := range commonAspectRatios {
+		if diff := math.Abs(ratio - bucket.Ratio); diff <= closest {
+			name, closest = bucket.Name, diff
+		}
+	}
+	return name, ratio
+}
+
+func getVideoAspectRatio(probe ffprobeOutput) (string, float64, error) {
+	width, height, err := getVideoDisplayDimensions(probe)
+	if err != nil {
+		return "", 0, err
+	}
+	name, ratio := classifyAspectRatio(width, height)
+	return name, ratio, nil
+}
+
+func hasAudioStream(probe ffprobeOutput) bool {
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+func isEncrypted(probe ffprobeOutput) bool {
+	for _, stream := range probe.Streams {
+		switch stream.CodecTagString {
+		case "encv", "enca", "encs", "enct":
+			return true
+		}
+	}
+	return false
+}
+
+func getVideoDuration(probe ffprobeOutput) (float64, error) {
+	value := probe.Format.Duration
+	if value == "" || value == "N/A" {
+		// Some containers only report duration on the streams; prefer the
+		// video stream's.
+		value = ""
+		for _, stream := range probe.Streams {
+			if stream.Duration == "" || stream.Duration == "N/A" {
+				continue
+			}
+			if value == "" || stream.CodecType == "video" {
+				value = stream.Duration
+			}
+			if stream.CodecType == "video" {
+				break
+			}
+		}
+	}
+	if value == "" {
+		return 0, errors.New("no duration found")
+	}
+	duration, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration: %v", err)
+	}
+	return duration, nil
+}
+
+// getVideoRecordedAt returns the container's creation_time, or nil if it isn't set.
+// Muxers that don't know the time write the epoch, so those are treated as unset.
+func getVideoRecordedAt(probe ffprobeOutput) (*time.Time, error) {
+	value := probe.Format.Tags.CreationTime
+	if value == "" {
+		return nil, nil
+	}
+	recordedAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creation_time %q: %w", value, err)
+	}
+	if recordedAt.Unix() <= 0 {
+		return nil, nil
+	}
+	recordedAt = recordedAt.UTC()
+	return &recordedAt, nil
+}
+
+func getVideoBitrate(probe ffprobeOutput) (int64, error) {
+	if probe.Format.BitRate != "" {
+		bitrate, err := strconv.ParseInt(probe.Format.BitRate, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse bit rate: %v", err)
+		}
+		return bitrate, nil
+	}
+
+	if probe.Format.Size == "" {
+		return 0, errors.New("no bit rate or size found")
+	}
+	size, err := strconv.ParseInt(probe.Format.Size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse size: %v", err)
+	}
+	duration, err := getVideoDuration(probe)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, errors.New("duration must be positive to derive bit rate")
+	}
+	return int64(float64(size*8) / duration), nil
+}
+
+func getVideoChapters(probe ffprobeOutput) (database.Chapters, error) {
+	if len(probe.Chapters) == 0 {
+		return nil, nil
+	}
+	chapters := make(database.Chapters, 0, len(probe.Chapters))
+	for _, chapter := range probe.Chapters {
+		start, err := strconv.ParseFloat(chapter.StartTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse chapter start time: %v", err)
+		}
+		end, err := strconv.ParseFloat(chapter.EndTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse chapter end time: %v", err)
+		}
+		chapters = append(chapters, database.Chapter{
+			Start: start,
+			End:   end,
+			Title: chapter.Tags.Title,
+		})
+	}
+	return chapters, nil
+}
+
+func (cfg *apiConfig) processVideoForFastStart(ctx context.Context, inputFilePath string) (string, error) {
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+
+	err := cfg.runFFmpegCommand(ctx, "processing video", nil, "ffmpeg", "-y", "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy", "-f", "mp4", processedFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	fileInfo, err := os.Stat(processedFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat processed file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return "", fmt.Errorf("processed file is empty")
+	}
+
+	return processedFilePath, nil
+}
+
+func parseTileLayout(layout string) (int, int, error) {
+	parts := strings.Split(layout, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid tile layout %q, expected COLUMNSxROWS", layout)
+	}
+	columns, err := strconv.Atoi(parts[0])
+	if err != nil || columns < 1 {
+		return 0, 0, fmt.Errorf("invalid tile layout %q, columns must be a positive integer", layout)
+	}
+	rows, err := strconv.Atoi(parts[1])
+	if err != nil || rows < 1 {
+		return 0, 0, fmt.Errorf("invalid tile layout %q, rows must be a positive integer", layout)
+	}
+	return columns, rows, nil
+}
+
+func contactSheetFilter(duration float64, frames, columns, rows int) string {
+	interval := duration / float64(frames)
+	return fmt.Sprintf("fps=1/%f,scale=320:-2,tile=%dx%d", interval, columns, rows)
+}
+
+func (cfg *apiConfig) generateContactSheet(ctx context.Context, inputFilePath string, duration float64, frames, columns, rows int) (string, error) {
+	outputFilePath := fmt.Sprintf("%s.contactsheet.jpg", inputFilePath)
+
+	err := cfg.runFFmpegCommand(ctx, "generating contact sheet", nil, "ffmpeg",
+		"-y",
+		"-i", inputFilePath,
+		"-vf", contactSheetFilter(duration, frames, columns, rows),
+		"-frames:v", "1",
+		"-update", "1",
+		"-q:v", "3",
+		outputFilePath,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return outputFilePath, nil
+}
+
+func (cfg *apiConfig) generateThumbnailFromVideo(ctx context.Context, filePath string, atSeconds float64) (string, error) {
+	args := []string{
+		"-y",
+		"-ss", strconv.FormatFloat(atSeconds, 'f', 3, 64),
+		"-i", filePath,
+		"-frames:v", "1",
+	}
+	outputFilePath := fmt.Sprintf("%s.thumbnail.jpg", filePath)
+	if cfg.autoThumbnailFormat == autoThumbnailWebP {
+		outputFilePath = fmt.Sprintf("%s.thumbnail.webp", filePath)
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	} else {
+		args = append(args, "-q:v", "3")
+	}
+	args = append(args, outputFilePath)
+
+	err := cfg.runFFmpegCommand(ctx, "generating thumbnail", nil, "ffmpeg", args...)
+	if err != nil {
+		return "", err
+	}
+
+	fileInfo, err := os.Stat(outputFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat thumbnail: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		os.Remove(outputFilePath)
+		return "", fmt.Errorf("generated thumbnail is empty")
+	}
+	return outputFilePath, nil
+}
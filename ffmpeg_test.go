@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestRunFFmpegCommandStopsOnCancel(t *testing.T) {
+	cfg := &apiConfig{ffmpegMaxAttempts: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := cfg.runFFmpegCommand(ctx, "sleeping", nil, "sleep", "5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("command wasn't stopped by the context, took %v", elapsed)
+	}
+}
+
+func TestRunFFmpegCommandRetryBackoffStopsOnCancel(t *testing.T) {
+	cfg := &apiConfig{
+		ffmpegMaxAttempts:     5,
+		ffmpegRetryableErrors: []string{"No such file"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := cfg.runFFmpegCommand(ctx, "listing", nil, "ls", "/does/not/exist")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > ffmpegRetryDelay*2 {
+		t.Fatalf("backoff ignored cancellation, took %v", elapsed)
+	}
+}
+
+func TestIsRecoverableFFmpegError(t *testing.T) {
+	cfg := &apiConfig{ffmpegRetryableErrors: []string{"Resource temporarily unavailable", "i/o error"}}
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"/tmp/in.mp4: Resource temporarily unavailable", true},
+		{"av_interleaved_write_frame(): I/O error", true},
+		{"/tmp/in.mp4: Invalid data found when processing input", false},
+		{"moov atom not found", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.isRecoverableFFmpegError(tt.stderr); got != tt.want {
+			t.Errorf("isRecoverableFFmpegError(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+// flakyCommand writes a script that prints stderr and fails on its first
+// failures runs, then succeeds. It returns the script and a run counter.
+func flakyCommand(t *testing.T, stderr string, failures int) (string, func() int) {
+	t.Helper()
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "flaky")
+	body := fmt.Sprintf(`#!/bin/sh
+echo x >> %q
+[ "$(wc -l < %q)" -gt %d ] && exit 0
+echo %q >&2
+exit 1
+`, counter, counter, failures, stderr)
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, func() int {
+		data, _ := os.ReadFile(counter)
+		return strings.Count(string(data), "\n")
+	}
+}
+
+func TestRunFFmpegCommandRetriesOnlyRecoverableErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		stderr      string
+		failures    int
+		maxAttempts int
+		wantErr     bool
+		wantRuns    int
+	}{
+		{"recoverable then success", "Resource temporarily unavailable", 1, 3, false, 2},
+		{"recoverable exhausts attempts", "Resource temporarily unavailable", 5, 2, true, 2},
+		{"format error isn't retried", "Invalid data found when processing input", 1, 3, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{
+				ffmpegMaxAttempts:     tt.maxAttempts,
+				ffmpegRetryableErrors: []string{"resource temporarily unavailable"},
+			}
+			script, runs := flakyCommand(t, tt.stderr, tt.failures)
+
+			err := cfg.runFFmpegCommand(context.Background(), "transcoding", nil, script)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var ffErr *ffmpegError
+			if tt.wantErr && (!errors.As(err, &ffErr) || !strings.Contains(ffErr.stderr, tt.stderr)) {
+				t.Errorf("error = %v, want an ffmpegError carrying %q", err, tt.stderr)
+			}
+			if got := runs(); got != tt.wantRuns {
+				t.Errorf("ran %d times, want %d", got, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestContactSheetFilter(t *testing.T) {
+	tests := []struct {
+		layout   string
+		duration float64
+		frames   int
+		want     string
+	}{
+		{"3x3", 9, 9, "fps=1/1.000000,scale=320:-2,tile=3x3"},
+		{"4x2", 10, 8, "fps=1/1.250000,scale=320:-2,tile=4x2"},
+		{"5x1", 60, 3, "fps=1/20.000000,scale=320:-2,tile=5x1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			columns, rows, err := parseTileLayout(tt.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := contactSheetFilter(tt.duration, tt.frames, columns, rows); got != tt.want {
+				t.Errorf("contactSheetFilter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTileLayoutRejectsInvalid(t *testing.T) {
+	for _, layout := range []string{"", "3", "3x", "x3", "0x3", "3x-1", "axb", "3x3x3"} {
+		if _, _, err := parseTileLayout(layout); err == nil {
+			t.Errorf("parseTileLayout(%q) succeeded, want an error", layout)
+		}
+	}
+}
+
+func TestClassifyAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          string
+	}{
+		{"1080p", 1920, 1080, "16:9"},
+		{"vertical 1080p", 1080, 1920, "9:16"},
+		{"VGA", 640, 480, "4:3"},
+		{"vertical VGA", 480, 640, "3:4"},
+		{"square", 1080, 1080, "1:1"},
+		{"ultrawide", 2560, 1080, "21:9"},
+		// Each ratio takes anything within 0.05 of it.
+		{"16:9 inside tolerance", 1818, 1000, "16:9"},
+		{"16:9 outside tolerance", 1838, 1000, "other"},
+		{"9:16 inside tolerance", 600, 1000, "9:16"},
+		{"9:16 outside tolerance", 620, 1000, "other"},
+		{"4:3 inside tolerance", 1380, 1000, "4:3"},
+		{"4:3 outside tolerance", 1390, 1000, "other"},
+		{"3:4 inside tolerance", 790, 1000, "3:4"},
+		{"3:4 outside tolerance", 810, 1000, "other"},
+		{"1:1 inside tolerance", 1040, 1000, "1:1"},
+		{"1:1 outside tolerance", 1060, 1000, "other"},
+		{"21:9 inside tolerance", 2370, 1000, "21:9"},
+		{"21:9 outside tolerance", 2390, 1000, "other"},
+		{"no width", 0, 1080, "other"},
+		{"no height", 1920, 0, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := classifyAspectRatio(tt.width, tt.height); got != tt.want {
+				t.Errorf("classifyAspectRatio(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetVideoOrientation(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, orientationLandscape},
+		{1080, 1920, orientationPortrait},
+		{1080, 1080, orientationSquare},
+		{1081, 1080, orientationLandscape},
+		{1080, 1081, orientationPortrait},
+	}
+	for _, tt := range tests {
+		if got := getVideoOrientation(tt.width, tt.height); got != tt.want {
+			t.Errorf("getVideoOrientation(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestGetVideoAspectRatio(t *testing.T) {
+	stream := func(width, height int, sar, dar string, rotation float64) ffprobeOutput {
+		s := ffprobeStream{CodecType: "video", Width: width, Height: height, SampleAspectRatio: sar, DisplayAspectRatio: dar}
+		if rotation != 0 {
+			s.SideDataList = append(s.SideDataList, struct {
+				Rotation float64 `json:"rotation"`
+			}{rotation})
+		}
+		return ffprobeOutput{Streams: []ffprobeStream{s}}
+	}
+	tests := []struct {
+		name  string
+		probe ffprobeOutput
+		want  string
+	}{
+		{"landscape", stream(1920, 1080, "", "", 0), "16:9"},
+		{"rotated phone video", stream(1920, 1080, "", "", -90), "9:16"},
+		{"anamorphic by display ratio", stream(720, 480, "", "16:9", 0), "16:9"},
+		{"anamorphic by sample ratio", stream(1440, 1080, "4:3", "", 0), "16:9"},
+		{"other", stream(1000, 1000*5/2, "", "", 0), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := getVideoAspectRatio(tt.probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("getVideoAspectRatio() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{[]string{"avc1", "mp4a"}, false},
+		{[]string{"encv", "mp4a"}, true},
+		{[]string{"avc1", "enca"}, true},
+		{[]string{"encs"}, true},
+		{[]string{"enct"}, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		probe := ffprobeOutput{}
+		for _, tag := range tt.tags {
+			probe.Streams = append(probe.Streams, ffprobeStream{CodecTagString: tag})
+		}
+		if got := isEncrypted(probe); got != tt.want {
+			t.Errorf("isEncrypted(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+// mp4BoxIndex returns where each top-level box of an MP4 first appears.
+func mp4BoxIndex(t *testing.T, path string) map[string]int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	index := map[string]int{}
+	for i := 0; ; i++ {
+		var header [8]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			return index
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		if size == 1 {
+			var large [8]byte
+			if _, err := io.ReadFull(f, large[:]); err != nil {
+				t.Fatal(err)
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(large[:])), 16
+		}
+		if _, seen := index[string(header[4:])]; !seen {
+			index[string(header[4:])] = i
+		}
+		if size == 0 {
+			return index
+		}
+		if _, err := f.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessVideoForFastStart(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	sample := filepath.Join(t.TempDir(), "sample.mp4")
+	err := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=2:size=320x240:rate=10", "-pix_fmt", "yuv420p", sample).Run()
+	if err != nil {
+		t.Fatalf("couldn't create sample video: %v", err)
+	}
+	if boxes := mp4BoxIndex(t, sample); boxes["moov"] < boxes["mdat"] {
+		t.Fatalf("sample already has moov first: %v", boxes)
+	}
+
+	cfg := &apiConfig{ffmpegMaxAttempts: 1}
+	processed, err := cfg.processVideoForFastStart(context.Background(), sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(processed)
+
+	boxes := mp4BoxIndex(t, processed)
+	moov, hasMoov := boxes["moov"]
+	mdat, hasMdat := boxes["mdat"]
+	if !hasMoov || !hasMdat || moov > mdat {
+		t.Errorf("top-level boxes = %v, want moov before mdat", boxes)
+	}
+}
+
+func TestProcessVideoForFastStartWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	input := filepath.Join(t.TempDir(), "in.mp4")
+	if err := os.WriteFile(input, testMP4(1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &apiConfig{ffmpegMaxAttempts: 1}
+	if _, err := cfg.processVideoForFastStart(context.Background(), input); !errors.Is(err, exec.ErrNotFound) {
+		t.Fatalf("expected exec.ErrNotFound, got %v", err)
+	}
+	if _, err := os.Stat(input + ".processing"); !os.IsNotExist(err) {
+		t.Errorf("intermediate file was left behind: %v", err)
+	}
+}
+
+func TestVideoUploadCleansUpFastStartFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		hasFFmpeg bool
+	}{
+		{"ffmpeg installed", true},
+		{"ffmpeg missing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.hasFFmpeg {
+				installFakeFFmpeg(t)
+			} else {
+				// Only ffprobe, written with shell builtins so PATH needs
+				// nothing else.
+				dir := t.TempDir()
+				script := "#!/bin/sh\necho '{\"streams\":[{\"codec_type\":\"video\",\"codec_name\":\"h264\",\"width\":1920,\"height\":1080}],\"format\":{\"duration\":\"10.0\"}}'\n"
+				if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0755); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("PATH", dir)
+			}
+			tempDir := t.TempDir()
+			t.Setenv("TMPDIR", tempDir)
+
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+			if w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000)); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+
+			leftovers, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range leftovers {
+				t.Errorf("temp file left behind: %s", entry.Name())
+			}
+		})
+	}
+}
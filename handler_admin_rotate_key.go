@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) isAdminRequest(r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		return false
+	}
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) == 1
+}
+
+// handlerAdminRotateVideoKey re-encrypts a video under a new KMS key. The
+// object is copied to a new key, the copy is checked, and only then is the
+// video pointed at it and the original deleted.
+func (cfg *apiConfig) handlerAdminRotateVideoKey(w http.ResponseWriter, r *http.Request) {
+	if !cfg.isAdminRequest(r) {
+		respondWithError(w, http.StatusForbidden, "Admin API key required", nil)
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	type parameters struct {
+		KMSKeyID string `json:"kms_key_id"`
+	}
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	kmsKeyID := params.KMSKeyID
+	if kmsKeyID == "" {
+		kmsKeyID = cfg.s3KMSKeyID
+	}
+	if kmsKeyID == "" {
+		respondWithError(w, http.StatusBadRequest, "No KMS key given and S3_KMS_KEY_ID is not set", nil)
+		return
+	}
+	if cfg.devMode {
+		respondWithError(w, http.StatusBadRequest, "Key rotation isn't available in dev mode", nil)
+		return
+	}
+
+	if !cfg.processingLocks.tryLock(videoID) {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return
+	}
+	defer cfg.processingLocks.unlock(videoID)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", err)
+		return
+	}
+	bucket, oldKey, ok := cfg.videoObject(video)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Video has no stored file", nil)
+		return
+	}
+
+	assetID, err := randomAssetID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate object key", err)
+		return
+	}
+	newKey := path.Join(path.Dir(oldKey), assetID+path.Ext(oldKey))
+
+	if err := cfg.copyObjectWithKMSKey(r.Context(), bucket, oldKey, newKey, kmsKeyID); err != nil {
+		if delErr := cfg.deleteObject(context.Background(), bucket, newKey); delErr != nil {
+			log.Printf("Couldn't clean up re-encrypted copy %s: %v", newKey, delErr)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't re-encrypt video", err)
+		return
+	}
+
+	videoURL := cfg.storedVideoURL(bucket, newKey)
+	video.VideoURL = &videoURL
+	video, err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithWriteError(w, "Couldn't update video", err)
+		return
+	}
+
+	if err := cfg.deleteObject(r.Context(), bucket, oldKey); err != nil && !isNotFound(err) {
+		log.Printf("Couldn't delete original object %s after key rotation: %v", oldKey, err)
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) copyObjectWithKMSKey(ctx context.Context, bucket, srcKey, dstKey, kmsKeyID string) error {
+	src, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(srcKey),
+		RequestPayer: cfg.requestPayer(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not read source object: %w", err)
+	}
+
+	_, err = cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(dstKey),
+		CopySource:           aws.String((&url.URL{Path: bucket + "/" + srcKey}).EscapedPath()),
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String(kmsKeyID),
+		RequestPayer:         cfg.requestPayer(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy object: %w", err)
+	}
+
+	dst, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(dstKey),
+		RequestPayer: cfg.requestPayer(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not read copied object: %w", err)
+	}
+	if aws.ToInt64(dst.ContentLength) != aws.ToInt64(src.ContentLength) {
+		return fmt.Errorf("copy is %d bytes, original is %d", aws.ToInt64(dst.ContentLength), aws.ToInt64(src.ContentLength))
+	}
+	// S3 reports the key as a full ARN even when it was given as an ID.
+	if dst.ServerSideEncryption != types.ServerSideEncryptionAwsKms || !strings.HasSuffix(aws.ToString(dst.SSEKMSKeyId), kmsKeyID) {
+		return fmt.Errorf("copy is not encrypted with %s", kmsKeyID)
+	}
+	return nil
+}
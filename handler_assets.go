@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+func (cfg *apiConfig) handlerServeAsset(w http.ResponseWriter, r *http.Request) {
+	// http.Dir rejects paths that would escape the assets root.
+	file, err := http.Dir(cfg.assetsRoot).Open("/" + r.PathValue("path"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid asset path", err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read asset", err)
+		return
+	}
+	if info.IsDir() {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
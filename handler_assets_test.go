@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeAssetRange(t *testing.T) {
+	cfg := newTestConfig(t)
+	data := testMP4(1000)
+	if err := os.WriteFile(filepath.Join(cfg.assetsRoot, "video.mp4"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		rangeHeader      string
+		want             int
+		wantContentRange string
+		wantBody         []byte
+	}{
+		{"first 100 bytes", "video.mp4", "bytes=0-99", http.StatusPartialContent, "bytes 0-99/1000", data[:100]},
+		{"suffix", "video.mp4", "bytes=-10", http.StatusPartialContent, "bytes 990-999/1000", data[990:]},
+		{"whole file", "video.mp4", "", http.StatusOK, "", data},
+		{"unsatisfiable", "video.mp4", "bytes=2000-", http.StatusRequestedRangeNotSatisfiable, "bytes */1000", nil},
+		{"missing", "nope.mp4", "bytes=0-99", http.StatusNotFound, "", nil},
+		{"escape attempt", "../tubely.db", "", http.StatusNotFound, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/assets/"+tt.path, nil)
+			req.SetPathValue("path", tt.path)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			cfg.handlerServeAsset(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, w.Code)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantBody != nil && !bytes.Equal(w.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body is %d bytes, want %d", w.Body.Len(), len(tt.wantBody))
+			}
+			if tt.want == http.StatusPartialContent && w.Header().Get("Content-Type") != "video/mp4" {
+				t.Errorf("Content-Type = %q, want video/mp4", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -58,11 +58,11 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		Token:     auth.HashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenLifetime),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		respondWithWriteError(w, "Couldn't save refresh token", err)
 		return
 	}
 
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const maxProcessingLogBytes = 4096
+
+var absolutePathPattern = regexp.MustCompile(`(^|[\s'"=(])(?:[A-Za-z]:)?(?:[/\\][^\s/\\:'"]+)+[/\\]?`)
+
+func sanitizeProcessingLog(output string) string {
+	output = absolutePathPattern.ReplaceAllString(output, "${1}[path]")
+	if len(output) > maxProcessingLogBytes {
+		output = "..." + output[len(output)-maxProcessingLogBytes:]
+	}
+	return output
+}
+
+func (cfg *apiConfig) saveProcessingLog(videoID uuid.UUID, err error) {
+	var ffErr *ffmpegError
+	if !errors.As(err, &ffErr) {
+		return
+	}
+	processingLog := sanitizeProcessingLog(ffErr.stderr)
+	if err := cfg.db.SetVideoProcessingLog(videoID, &processingLog); err != nil {
+		log.Printf("Couldn't save processing log for video %s: %v", videoID, err)
+	}
+}
+
+func (cfg *apiConfig) handlerVideoProcessingLog(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ProcessingLog *string `json:"processing_log"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's processing log", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		ProcessingLog: video.ProcessingLog,
+	})
+}
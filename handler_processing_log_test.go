@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestSanitizeProcessingLog(t *testing.T) {
+	long := strings.Repeat("a", maxProcessingLogBytes) + "tail"
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"no paths", "Invalid data found", "Invalid data found"},
+		{"unix path", "/tmp/tubely-upload-123.mp4: Invalid data", "[path]: Invalid data"},
+		{"quoted path", "Error opening input file '/var/lib/tubely/in.mp4'.", "Error opening input file '[path]'."},
+		{"windows path", `C:\Users\me\in.mp4: No such file`, "[path]: No such file"},
+		{"option value", "-i=/tmp/x/in.mp4 failed", "-i=[path] failed"},
+		{"ratio kept", "aspect 16/9 kept", "aspect 16/9 kept"},
+		{"truncated to the end", long, "..." + long[len(long)-maxProcessingLogBytes:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeProcessingLog(tt.output); got != tt.want {
+				t.Errorf("sanitizeProcessingLog(%q) = %q, want %q", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func getProcessingLog(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token string) (*httptest.ResponseRecorder, *string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID.String()+"/processing-log", nil)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.handlerVideoProcessingLog(w, req)
+
+	var resp struct {
+		ProcessingLog *string `json:"processing_log"`
+	}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, resp.ProcessingLog
+}
+
+func TestVideoProcessingLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		failOn     []string
+		viewer     string
+		wantStatus int
+		wantLog    string
+	}{
+		{"failed upload, owner", []string{"tile="}, "owner", http.StatusOK, "fake ffmpeg failed"},
+		{"successful upload, owner", nil, "owner", http.StatusOK, ""},
+		{"failed upload, other user", []string{"tile="}, "other", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeFFmpeg(t, tt.failOn...)
+			cfg := newTestConfig(t)
+			cfg.contactSheetFrames = 4
+			cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+			userID, token := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+			uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+
+			viewerToken := token
+			if tt.viewer == "other" {
+				viewerToken = otherToken
+			}
+			w, processingLog := getProcessingLog(t, cfg, video.ID, viewerToken)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, w.Code, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if strings.Contains(w.Body.String(), "fake ffmpeg failed") {
+					t.Errorf("log leaked to another user: %s", w.Body)
+				}
+				return
+			}
+			if tt.wantLog == "" {
+				if processingLog != nil {
+					t.Errorf("processing log = %q, want null", *processingLog)
+				}
+				return
+			}
+			if processingLog == nil || !strings.Contains(*processingLog, tt.wantLog) {
+				t.Errorf("processing log = %v, want it to contain %q", processingLog, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestVideoReuploadClearsProcessingLog(t *testing.T) {
+	installFakeFFmpeg(t, "tile=")
+	cfg := newTestConfig(t)
+	cfg.contactSheetFrames = 4
+	cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+	if w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+
+	installFakeFFmpeg(t)
+	if w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000)); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if _, processingLog := getProcessingLog(t, cfg, video.ID, token); processingLog != nil {
+		t.Errorf("processing log = %q after a successful upload, want null", *processingLog)
+	}
+}
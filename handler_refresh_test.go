@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func postRefresh(cfg *apiConfig, refreshToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	w := httptest.NewRecorder()
+	cfg.handlerRefresh(w, req)
+	return w
+}
+
+func issueRefreshToken(t *testing.T, cfg *apiConfig, expiresAt time.Time) string {
+	t.Helper()
+	userID, _ := createTestUser(t, cfg, "user@example.com")
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     auth.HashRefreshToken(token),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	cfg := newTestConfig(t)
+	token := issueRefreshToken(t, cfg, time.Now().Add(time.Hour))
+
+	w := postRefresh(cfg, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateJWT(resp.Token, cfg.jwtSecret); err != nil {
+		t.Errorf("access token is invalid: %v", err)
+	}
+	if resp.RefreshToken == "" || resp.RefreshToken == token {
+		t.Fatalf("expected a new refresh token, got %q", resp.RefreshToken)
+	}
+
+	stored, err := cfg.db.GetRefreshToken(auth.HashRefreshToken(resp.RefreshToken))
+	if err != nil || stored.Token == "" {
+		t.Fatalf("new refresh token wasn't stored hashed: %v", err)
+	}
+	if w := postRefresh(cfg, resp.RefreshToken); w.Code != http.StatusOK {
+		t.Errorf("expected the rotated token to work, got %d", w.Code)
+	}
+}
+
+func TestRefreshRejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, cfg *apiConfig) string
+	}{
+		{
+			name: "expired",
+			setup: func(t *testing.T, cfg *apiConfig) string {
+				return issueRefreshToken(t, cfg, time.Now().Add(-time.Minute))
+			},
+		},
+		{
+			name: "reused after rotation",
+			setup: func(t *testing.T, cfg *apiConfig) string {
+				token := issueRefreshToken(t, cfg, time.Now().Add(time.Hour))
+				if w := postRefresh(cfg, token); w.Code != http.StatusOK {
+					t.Fatalf("first refresh: expected 200, got %d", w.Code)
+				}
+				return token
+			},
+		},
+		{
+			name: "revoked",
+			setup: func(t *testing.T, cfg *apiConfig) string {
+				token := issueRefreshToken(t, cfg, time.Now().Add(time.Hour))
+				if err := cfg.db.RevokeRefreshToken(auth.HashRefreshToken(token)); err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+		},
+		{
+			name: "stored unhashed",
+			setup: func(t *testing.T, cfg *apiConfig) string {
+				userID, _ := createTestUser(t, cfg, "legacy@example.com")
+				_, err := cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+					Token:     "raw-token",
+					UserID:    userID,
+					ExpiresAt: time.Now().Add(time.Hour),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "raw-token"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			token := tt.setup(t, cfg)
+			if w := postRefresh(cfg, token); w.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func postRevoke(cfg *apiConfig, refreshToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/revoke", nil)
+	if refreshToken != "" {
+		req.Header.Set("Authorization", "Bearer "+refreshToken)
+	}
+	w := httptest.NewRecorder()
+	cfg.handlerRevoke(w, req)
+	return w
+}
+
+func TestRevokeEndsSession(t *testing.T) {
+	cfg := newTestConfig(t)
+	token := issueRefreshToken(t, cfg, time.Now().Add(time.Hour))
+
+	if w := postRevoke(cfg, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("revoke without a token: expected 400, got %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := postRevoke(cfg, token); w.Code != http.StatusNoContent {
+			t.Fatalf("revoke %d: expected 204, got %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	stored, err := cfg.db.GetRefreshToken(auth.HashRefreshToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RevokedAt == nil {
+		t.Error("revoked_at wasn't set")
+	}
+	if w := postRefresh(cfg, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after revoke: expected 401, got %d: %s", w.Code, w.Body)
+	}
+}
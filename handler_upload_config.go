@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	videoMediaTypes     = []string{"video/mp4"}
+	thumbnailMediaTypes = []string{"image/jpeg", "image/png", "image/webp"}
+	videoAspectRatios   = []string{"16:9", "9:16", "4:3", "3:4", "1:1", "21:9", "other"}
+	videoOrientations   = []string{orientationLandscape, orientationPortrait, orientationSquare}
+
+	mediaTypeExtensions = map[string][]string{
+		"video/mp4":  {".mp4", ".m4v"},
+		"image/jpeg": {".jpg", ".jpeg"},
+		"image/png":  {".png"},
+		"image/webp": {".webp"},
+	}
+)
+
+func extensionMatchesMediaType(filename, mediaType string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return slices.Contains(mediaTypeExtensions[mediaType], ext)
+}
+
+// sniffMediaType detects the media type from the first 512 bytes, the most
+// http.DetectContentType looks at.
+func sniffMediaType(header []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(header))
+	return mediaType
+}
+
+func sniffReadSeeker(file io.ReadSeeker) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return sniffMediaType(header[:n]), nil
+}
+
+func (cfg *apiConfig) handlerUploadConfig(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		MaxVideoSizeBytes     int64    `json:"max_video_size_bytes"`
+		MaxThumbnailSizeBytes int64    `json:"max_thumbnail_size_bytes"`
+		MaxDurationSeconds    int      `json:"max_duration_seconds"`
+		MaxStreamCount        int      `json:"max_stream_count"`
+		MinVideoWidth         int      `json:"min_video_width"`
+		MinVideoHeight        int      `json:"min_video_height"`
+		VideoMediaTypes       []string `json:"video_media_types"`
+		ThumbnailMediaTypes   []string `json:"thumbnail_media_types"`
+		AspectRatios          []string `json:"aspect_ratios"`
+		Orientations          []string `json:"orientations"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MaxVideoSizeBytes:     cfg.maxVideoUploadBytes,
+		MaxThumbnailSizeBytes: cfg.maxThumbnailUploadBytes,
+		MaxDurationSeconds:    cfg.maxVideoDurationSeconds,
+		MaxStreamCount:        cfg.maxStreamCount,
+		MinVideoWidth:         cfg.minVideoWidth,
+		MinVideoHeight:        cfg.minVideoHeight,
+		VideoMediaTypes:       videoMediaTypes,
+		ThumbnailMediaTypes:   thumbnailMediaTypes,
+		AspectRatios:          videoAspectRatios,
+		Orientations:          videoOrientations,
+	})
+}
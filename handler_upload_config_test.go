@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newFormUploadRequest builds a video or thumbnail upload whose file part
+// declares filename and mediaType, whatever data actually is.
+func newFormUploadRequest(t *testing.T, videoID uuid.UUID, token, field, filename, mediaType string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filename))
+	header.Set("Content-Type", mediaType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/"+field+"_upload/"+videoID.String(), &body)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestExtensionMatchesMediaType(t *testing.T) {
+	tests := []struct {
+		filename  string
+		mediaType string
+		want      bool
+	}{
+		{"clip.mp4", "video/mp4", true},
+		{"clip.M4V", "video/mp4", true},
+		{"clip.mov", "video/mp4", false},
+		{"clip", "video/mp4", false},
+		{"photo.jpeg", "image/jpeg", true},
+		{"photo.JPG", "image/jpeg", true},
+		{"photo.png", "image/jpeg", false},
+		{"image.png", "image/png", true},
+		{"image.webp", "image/webp", true},
+		{"image.webp.png", "image/webp", false},
+		{"clip.mp4", "video/quicktime", false},
+	}
+	for _, tt := range tests {
+		if got := extensionMatchesMediaType(tt.filename, tt.mediaType); got != tt.want {
+			t.Errorf("extensionMatchesMediaType(%q, %q) = %v, want %v", tt.filename, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestStrictExtensionCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		upload   string
+		filename string
+		strict   bool
+		want     int
+	}{
+		{"video matching", "video", "clip.mp4", true, http.StatusOK},
+		{"video mismatch strict", "video", "clip.mov", true, http.StatusBadRequest},
+		{"video mismatch lenient", "video", "clip.mov", false, http.StatusOK},
+		{"thumbnail matching", "thumbnail", "thumb.png", true, http.StatusOK},
+		{"thumbnail mismatch strict", "thumbnail", "thumb.jpg", true, http.StatusBadRequest},
+		{"thumbnail mismatch lenient", "thumbnail", "thumb.jpg", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeFFmpeg(t)
+			cfg := newTestConfig(t)
+			cfg.strictExtensionCheck = tt.strict
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			handler, mediaType, data := cfg.handlerUploadVideo, "video/mp4", testMP4(1000)
+			if tt.upload == "thumbnail" {
+				handler, mediaType, data = cfg.handlerUploadThumbnail, "image/png", testPNG(t, 8, 8)
+			}
+			w := httptest.NewRecorder()
+			handler(w, newFormUploadRequest(t, video.ID, token, tt.upload, tt.filename, mediaType, data))
+
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestUploadSniffsContents(t *testing.T) {
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, noisyImage(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	executable := append([]byte("MZ\x90\x00"), make([]byte, 1000)...)
+
+	tests := []struct {
+		name      string
+		field     string
+		filename  string
+		mediaType string
+		data      []byte
+		want      int
+	}{
+		{"genuine MP4", "video", "clip.mp4", "video/mp4", testMP4(1000), http.StatusOK},
+		{"executable labeled MP4", "video", "clip.mp4", "video/mp4", executable, http.StatusBadRequest},
+		{"PNG labeled MP4", "video", "clip.mp4", "video/mp4", testPNG(t, 8, 8), http.StatusBadRequest},
+		{"genuine JPEG", "thumbnail", "thumb.jpg", "image/jpeg", jpegData.Bytes(), http.StatusOK},
+		{"PNG labeled JPEG", "thumbnail", "thumb.jpg", "image/jpeg", testPNG(t, 8, 8), http.StatusBadRequest},
+		{"executable labeled PNG", "thumbnail", "thumb.png", "image/png", executable, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeFFmpeg(t)
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			handler := cfg.handlerUploadVideo
+			if tt.field == "thumbnail" {
+				handler = cfg.handlerUploadThumbnail
+			}
+			w := httptest.NewRecorder()
+			handler(w, newFormUploadRequest(t, video.ID, token, tt.field, tt.filename, tt.mediaType, tt.data))
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "don't match declared type") {
+				t.Errorf("rejected for the wrong reason: %s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func (cfg *apiConfig) handlerUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	const policyExpiry = 15 * time.Minute
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if cfg.devMode {
+		respondWithError(w, http.StatusBadRequest, "Browser uploads are not available in dev mode", nil)
+		return
+	}
+
+	bucket, err := cfg.requestBucket(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Storage bucket not allowed", err)
+		return
+	}
+
+	mediaType := videoMediaTypes[0]
+	keyPrefix := cfg.userKey(userID, fmt.Sprintf("uploads/%s", userID)) + "/"
+	assetID, err := randomAssetID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate object key", err)
+		return
+	}
+	key := keyPrefix + assetID + mediaTypeToExt(mediaType)
+
+	policy, err := cfg.presignPostPolicy(r.Context(), bucket, key, keyPrefix, mediaType, cfg.maxVideoUploadBytes, policyExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload policy", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
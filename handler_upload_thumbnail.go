@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
@@ -30,11 +39,37 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !cfg.processingLocks.tryLock(videoID) {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return
+	}
+	defer cfg.processingLocks.unlock(videoID)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+	if video.ThumbnailURL != nil && cfg.thumbnailReplaceMode == thumbnailReplaceReject {
+		respondWithError(w, http.StatusConflict, "Video already has a thumbnail", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxThumbnailUploadBytes)
+
 	const maxMemory = 10 << 20 // 10 MB
 	r.ParseMultipartForm(maxMemory)
 
 	file, header, err := r.FormFile("thumbnail")
 	if err != nil {
+		if uploadTimedOut(r, err) {
+			respondWithError(w, http.StatusRequestTimeout, "Upload took too long", err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
@@ -45,42 +80,192 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+	if !slices.Contains(thumbnailMediaTypes, mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported media type %q, allowed: %s", mediaType, strings.Join(thumbnailMediaTypes, ", ")), nil)
+		return
+	}
+	if cfg.strictExtensionCheck && !extensionMatchesMediaType(header.Filename, mediaType) {
+		respondWithError(w, http.StatusBadRequest, "File extension does not match its content type", nil)
 		return
 	}
 
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	sniffedType, err := sniffReadSeeker(file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return
+	}
+	if sniffedType != mediaType {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File contents (%s) don't match declared type %q", sniffedType, mediaType), nil)
+		return
+	}
 
-	dst, err := os.Create(assetDiskPath)
+	// The header alone says how much decoding will allocate, so oversize
+	// images are turned away before any pixels are read.
+	imgConfig, _, err := image.DecodeConfig(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to decode image", err)
 		return
 	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	// Phones store portrait photos sideways with an EXIF Orientation tag.
+	// The re-encode drops EXIF, so the rotation is applied to the pixels.
+	orientation := 1
+	if mediaType == "image/jpeg" {
+		orientation = jpegOrientation(file)
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+			return
+		}
+	}
+
+	fitWidth, fitHeight := orientedSize(imgConfig.Width, imgConfig.Height, orientation)
+	if cfg.thumbnailAspectMode != "" && video.Width > 0 && video.Height > 0 {
+		fitWidth, fitHeight = fittedSize(fitWidth, fitHeight, video.Width, video.Height, cfg.thumbnailAspectMode)
+	}
+	if cfg.imageTooLarge(imgConfig.Width, imgConfig.Height) || cfg.imageTooLarge(fitWidth, fitHeight) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Image is %dx%d, which is too large to process", imgConfig.Width, imgConfig.Height), nil)
+		return
+	}
+
+	img, _, err := image.Decode(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to decode image", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
 		return
 	}
 
-	url := cfg.getAssetURL(assetPath)
+	original := img
+	img = applyOrientation(img, orientation)
+	if cfg.thumbnailAspectMode != "" && video.Width > 0 && video.Height > 0 {
+		img = fitToAspectRatio(img, video.Width, video.Height, cfg.thumbnailAspectMode)
+	}
+	img = resizeImage(img, cfg.maxThumbnailDimension)
+	// Sampling the downscaled image is much cheaper for large uploads.
+	dominantColor := averageColorHex(img)
+	palette := extractPalette(img, paletteSize)
+
+	// There's no WebP encoder, so modified WebP images are stored as JPEG.
+	outputType := mediaType
+	if mediaType == "image/webp" && (cfg.transcodeWebP || img != original) {
+		outputType = "image/jpeg"
+	}
+
+	assetKey, err := renderKeyTemplate(cfg.thumbnailKeyTemplate, keyTemplateValues{
+		UserID:  userID,
+		VideoID: videoID,
+		Ext:     mediaTypeToExt(outputType),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate asset path", err)
+		return
+	}
+
+	var body io.ReadSeeker = file
+	size := header.Size
+	// JPEGs are always re-encoded so EXIF data (GPS, device info) is dropped.
+	if img != original || outputType != mediaType || outputType == "image/jpeg" {
+		var buf bytes.Buffer
+		if err = encodeImage(&buf, img, outputType, cfg.thumbnailJPEGQuality); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error encoding image", err)
+			return
+		}
+		body = bytes.NewReader(buf.Bytes())
+		size = int64(buf.Len())
+	}
+	replacedSize := video.ThumbnailSize
+	if cfg.thumbnailReplaceMode == thumbnailReplaceKeep {
+		replacedSize = video.PreviousThumbnailSize
+	}
+	overQuota, err := cfg.exceedsStorageQuota(userID, replacedSize, size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+	if overQuota {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload would exceed your storage quota", nil)
+		return
+	}
+
+	url, storedSize, err := cfg.storeThumbnail(r.Context(), cfg.s3Bucket, userID, assetKey, body, outputType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+		return
+	}
+
+	// In keep mode the replaced thumbnail becomes the previous version, and
+	// the version before that is dropped.
+	var staleThumbnailURL *string
+	if video.ThumbnailURL != nil {
+		staleThumbnailURL = video.ThumbnailURL
+		if cfg.thumbnailReplaceMode == thumbnailReplaceKeep {
+			staleThumbnailURL = video.PreviousThumbnailURL
+			video.PreviousThumbnailURL = video.ThumbnailURL
+			video.PreviousThumbnailSize = video.ThumbnailSize
+		}
+	}
 	video.ThumbnailURL = &url
-	err = cfg.db.UpdateVideo(video)
+	video.ThumbnailSize = storedSize
+	video.ThumbnailIsAuto = false
+	video.DominantColor = &dominantColor
+	video.Palette = palette
+	video, err = cfg.db.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithWriteError(w, "Couldn't update video", err)
+		return
+	}
+
+	if staleThumbnailURL != nil {
+		if err := cfg.removeAsset(r.Context(), *staleThumbnailURL); err != nil {
+			log.Printf("Couldn't remove previous thumbnail for video %s: %v", videoID, err)
+		}
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, video)
 }
+
+// storeThumbnail returns the thumbnail's URL and how many bytes were stored.
+// The body stays seekable so the S3 client can hash and retry it.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, bucket string, userID uuid.UUID, assetKey string, body io.ReadSeeker, contentType string) (string, int64, error) {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	if cfg.s3Bucket != "" {
+		key := cfg.userKey(userID, path.Join("thumbnails", assetKey))
+		if err := cfg.uploadToS3(ctx, bucket, key, body, contentType); err != nil {
+			return "", 0, err
+		}
+		return cfg.storedAssetURL(bucket, key), size, nil
+	}
+
+	assetPath := cfg.userKey(userID, assetKey)
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	if err := os.MkdirAll(filepath.Dir(assetDiskPath), 0755); err != nil {
+		return "", 0, err
+	}
+	dst, err := os.Create(assetDiskPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, body); err != nil {
+		return "", 0, err
+	}
+	return cfg.getAssetURL(assetPath), size, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newThumbnailUploadRequest declares the type the data sniffs as, with a
+// matching file extension.
+func newThumbnailUploadRequest(t *testing.T, videoID uuid.UUID, token string, data []byte) *http.Request {
+	t.Helper()
+	mediaType := http.DetectContentType(data)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="thumbnail"; filename="thumb%s"`, mediaTypeToExt(mediaType)))
+	header.Set("Content-Type", mediaType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/thumbnail_upload/"+videoID.String(), &body)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func uploadThumbnail(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cfg.handlerUploadThumbnail(w, newThumbnailUploadRequest(t, videoID, token, data))
+	return w
+}
+
+func assetExists(cfg *apiConfig, assetURL *string) bool {
+	if assetURL == nil {
+		return false
+	}
+	_, err := os.Stat(cfg.getAssetDiskPath(strings.TrimPrefix(*assetURL, cfg.getAssetURL(""))))
+	return err == nil
+}
+
+func TestThumbnailReplaceModes(t *testing.T) {
+	tests := []struct {
+		mode         string
+		secondStatus int
+		keepsFirst   bool
+	}{
+		{thumbnailReplaceOverwrite, http.StatusOK, false},
+		{thumbnailReplaceReject, http.StatusConflict, true},
+		{thumbnailReplaceKeep, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.thumbnailReplaceMode = tt.mode
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+			data := testPNG(t, 8, 8)
+
+			if w := uploadThumbnail(t, cfg, video.ID, token, data); w.Code != http.StatusOK {
+				t.Fatalf("first upload: expected 200, got %d: %s", w.Code, w.Body)
+			}
+			first, _ := cfg.db.GetVideo(video.ID)
+
+			if w := uploadThumbnail(t, cfg, video.ID, token, data); w.Code != tt.secondStatus {
+				t.Fatalf("second upload: expected %d, got %d: %s", tt.secondStatus, w.Code, w.Body)
+			}
+			second, _ := cfg.db.GetVideo(video.ID)
+
+			if got := assetExists(cfg, first.ThumbnailURL); got != tt.keepsFirst {
+				t.Errorf("first thumbnail exists = %v, want %v", got, tt.keepsFirst)
+			}
+			if !assetExists(cfg, second.ThumbnailURL) {
+				t.Error("current thumbnail is missing")
+			}
+			if tt.mode == thumbnailReplaceKeep {
+				if second.PreviousThumbnailURL == nil || *second.PreviousThumbnailURL != *first.ThumbnailURL {
+					t.Errorf("previous thumbnail = %v, want %s", second.PreviousThumbnailURL, *first.ThumbnailURL)
+				}
+			} else if second.PreviousThumbnailURL != nil {
+				t.Errorf("unexpected previous thumbnail %s", *second.PreviousThumbnailURL)
+			}
+		})
+	}
+}
+
+func TestThumbnailKeepModeDropsOldestVersion(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.thumbnailReplaceMode = thumbnailReplaceKeep
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+	data := testPNG(t, 8, 8)
+
+	var versions []*string
+	for i := 0; i < 3; i++ {
+		if w := uploadThumbnail(t, cfg, video.ID, token, data); w.Code != http.StatusOK {
+			t.Fatalf("upload %d: expected 200, got %d", i+1, w.Code)
+		}
+		current, _ := cfg.db.GetVideo(video.ID)
+		versions = append(versions, current.ThumbnailURL)
+	}
+
+	if assetExists(cfg, versions[0]) {
+		t.Error("oldest thumbnail wasn't removed")
+	}
+	if !assetExists(cfg, versions[1]) || !assetExists(cfg, versions[2]) {
+		t.Error("the two newest thumbnails should be kept")
+	}
+}
+
+// headerCounter counts WriteHeader calls, which httptest.ResponseRecorder
+// silently drops after the first.
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (h *headerCounter) WriteHeader(code int) {
+	h.writes++
+	h.ResponseRecorder.WriteHeader(code)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy exhausted")
+}
+
+func TestThumbnailUploadRandFailure(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	original := randReader
+	randReader = failingReader{}
+	t.Cleanup(func() { randReader = original })
+
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	cfg.handlerUploadThumbnail(w, newThumbnailUploadRequest(t, video.ID, token, testPNG(t, 64, 64)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+	if w.writes != 1 {
+		t.Errorf("WriteHeader called %d times, want 1", w.writes)
+	}
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ThumbnailURL != nil {
+		t.Errorf("thumbnail was saved: %s", *updated.ThumbnailURL)
+	}
+}
+
+// pngHeader returns a PNG signature and IHDR chunk declaring width x height,
+// which is all image.DecodeConfig reads.
+func pngHeader(width, height int) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(height))
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, 13)
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestThumbnailUploadRejectsOversizeImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		aspectMode string
+		want       int
+	}{
+		{"huge declared size", pngHeader(30000, 30000), "", http.StatusBadRequest},
+		{"too many pixels", pngHeader(5000, 5000), "", http.StatusBadRequest},
+		{"padded canvas too large", testPNG(t, 4000, 100), thumbnailAspectPad, http.StatusBadRequest},
+		{"cropped canvas fits", testPNG(t, 4000, 100), thumbnailAspectCrop, http.StatusOK},
+		{"within limits", testPNG(t, 64, 64), "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.maxImageSourceDimension = 8000
+			cfg.maxImageSourcePixels = 4_000_000
+			cfg.thumbnailAspectMode = tt.aspectMode
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+			video.Width, video.Height = 1920, 1080
+			if _, err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+
+			w := uploadThumbnail(t, cfg, video.ID, token, tt.data)
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "too large") {
+				t.Errorf("rejected for the wrong reason: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestThumbnailUploadJPEGQuality(t *testing.T) {
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, noisyImage(64, 64), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]int64{}
+	for _, quality := range []int{10, 95} {
+		cfg := newTestConfig(t)
+		cfg.thumbnailJPEGQuality = quality
+		userID, token := createTestUser(t, cfg, "user@example.com")
+		video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+		if w := uploadThumbnail(t, cfg, video.ID, token, source.Bytes()); w.Code != http.StatusOK {
+			t.Fatalf("quality %d: expected 200, got %d: %s", quality, w.Code, w.Body)
+		}
+		updated, err := cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[quality] = updated.ThumbnailSize
+	}
+	if sizes[10] >= sizes[95] {
+		t.Errorf("quality 10 stored %d bytes, quality 95 stored %d; want the lower quality to be smaller", sizes[10], sizes[95])
+	}
+}
+
+// testWebP is a 1x1 lossy WebP; x/image only decodes WebP, so tests can't
+// encode their own.
+const testWebP = "UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA"
+
+func TestThumbnailUploadWebP(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(testWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		transcodeWebP bool
+		wantExt       string
+		wantType      string
+	}{
+		{"stored as WebP", false, ".webp", "image/webp"},
+		{"transcoded to JPEG", true, ".jpeg", "image/jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.transcodeWebP = tt.transcodeWebP
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if w := uploadThumbnail(t, cfg, video.ID, token, data); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+			updated, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.ThumbnailURL == nil {
+				t.Fatal("thumbnail wasn't saved")
+			}
+			if ext := filepath.Ext(*updated.ThumbnailURL); ext != tt.wantExt {
+				t.Errorf("stored extension = %q, want %q", ext, tt.wantExt)
+			}
+			stored, err := os.ReadFile(cfg.getAssetDiskPath(strings.TrimPrefix(*updated.ThumbnailURL, cfg.getAssetURL(""))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := http.DetectContentType(stored); got != tt.wantType {
+				t.Errorf("stored content = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
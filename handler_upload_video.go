@@ -1,26 +1,52 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
+	"math"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+const autoThumbnailSeconds = 1.0
+
+const (
+	autoThumbnailJPEG = "jpeg"
+	autoThumbnailWebP = "webp"
+)
+
+type uploadStats struct {
+	Bytes          int64   `json:"bytes"`
+	Seconds        float64 `json:"seconds"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+type videoResponse struct {
+	database.Video
+	Key         string       `json:"key,omitempty"`
+	UploadStats *uploadStats `json:"upload_stats,omitempty"`
+}
+
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	uploadStart := time.Now()
+	r = r.WithContext(cfg.withRetryBudget(r.Context()))
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -41,6 +67,14 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The lock is taken before the row is read, so nothing else can change
+	// it between the read and this handler's writes.
+	if !cfg.processingLocks.tryLock(videoID) {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return
+	}
+	defer cfg.processingLocks.unlock(videoID)
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
@@ -51,35 +85,122 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	file, handler, err := r.FormFile("video")
+	if left := cfg.uploadCooldowns.remaining(videoID, cfg.uploadCooldown); left > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Video was just uploaded, try again later", nil)
+		return
+	}
+
+	progress := cfg.uploadProgress.start(videoID, r.ContentLength)
+	defer cfg.uploadProgress.finish(videoID, progress)
+	defer func() {
+		if cfg.uploadCooldown > 0 && progress.succeeded.Load() {
+			cfg.uploadCooldowns.mark(videoID)
+		}
+	}()
+	r.Body = progressReader{ReadCloser: r.Body, entry: progress}
+
+	bucket, err := cfg.requestBucket(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Storage bucket not allowed", err)
+		return
+	}
+
+	large := cfg.multipartThreshold > 0 && r.ContentLength > cfg.multipartThreshold
+	part, err := openVideoPart(r, large)
 	if err != nil {
+		if uploadTimedOut(r, err) {
+			respondWithError(w, http.StatusRequestTimeout, "Upload took too long", err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	mediaType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
+	mediaType, _, err := mime.ParseMediaType(part.header.Get("Content-Type"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
+	if !slices.Contains(videoMediaTypes, mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported media type %q, allowed: %s", mediaType, strings.Join(videoMediaTypes, ", ")), nil)
+		return
+	}
+	if cfg.strictExtensionCheck && !extensionMatchesMediaType(part.filename, mediaType) {
+		respondWithError(w, http.StatusBadRequest, "File extension does not match its content type", nil)
 		return
 	}
 
+	metadata, err := objectMetadata(r.Header, userID, part.filename)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid object metadata", err)
+		return
+	}
+
+	body := bufio.NewReaderSize(part, 512)
+	header, err := body.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if uploadTimedOut(r, err) {
+			respondWithError(w, http.StatusRequestTimeout, "Upload took too long", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to read form file", err)
+		return
+	}
+	if sniffedType := sniffMediaType(header); sniffedType != mediaType {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File contents (%s) don't match declared type %q", sniffedType, mediaType), nil)
+		return
+	}
+
+	if part.size >= 0 {
+		overQuota, err := cfg.exceedsStorageQuota(userID, mediaSize(video), part.size)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+			return
+		}
+		if overQuota {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Upload would exceed your storage quota", nil)
+			return
+		}
+	}
+
 	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
 		return
 	}
-	defer os.Remove(tempFile.Name())
+	processingFailed := false
+	defer func() { cfg.cleanupTempFile(tempFile.Name(), videoID, processingFailed) }()
 	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
+	uploadedBytes, checksum, err := spoolUpload(tempFile, body)
+	if err != nil {
+		if uploadTimedOut(r, err) {
+			respondWithError(w, http.StatusRequestTimeout, "Upload took too long", err)
+			return
+		}
+		if large {
+			respondWithError(w, http.StatusBadRequest, "Unable to read form file", err)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
 		return
 	}
+	if part.size < 0 {
+		// A part read off the body only has a size once it's on disk, but that's
+		// still before anything is processed or stored.
+		overQuota, err := cfg.exceedsStorageQuota(userID, mediaSize(video), uploadedBytes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+			return
+		}
+		if overQuota {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Upload would exceed your storage quota", nil)
+			return
+		}
+	}
+	uploadSeconds := time.Since(uploadStart).Seconds()
 
 	_, err = tempFile.Seek(0, io.SeekStart)
 	if err != nil {
@@ -87,30 +208,151 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	directory := ""
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	probe, err := cfg.probeVideo(r.Context(), tempFile.Name())
+	if err != nil {
+		processingFailed = true
+		cfg.saveProcessingLog(videoID, err)
+		respondWithError(w, pipelineErrorStatus(err), "Error probing video", err)
+		return
+	}
+
+	if isEncrypted(probe) {
+		respondWithError(w, http.StatusUnprocessableEntity, "Encrypted media not supported", nil)
+		return
+	}
+
+	if cfg.maxStreamCount > 0 && len(probe.Streams) > cfg.maxStreamCount {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video has %d streams, the maximum is %d", len(probe.Streams), cfg.maxStreamCount), nil)
+		return
+	}
+
+	if _, err := primaryStream(probe); err != nil {
+		respondWithError(w, http.StatusBadRequest, "File doesn't contain a video stream", err)
+		return
+	}
+
+	duration, durationErr := getVideoDuration(probe)
+	if cfg.maxVideoDurationSeconds > 0 {
+		if durationErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't determine video duration", durationErr)
+			return
+		}
+		if duration > float64(cfg.maxVideoDurationSeconds) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video exceeds maximum duration of %d seconds", cfg.maxVideoDurationSeconds), nil)
+			return
+		}
+	}
+
+	width, height, err := getVideoDimensions(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error determining dimensions", err)
+		return
+	}
+	if width < cfg.minVideoWidth || height < cfg.minVideoHeight {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video is %dx%d, the minimum is %dx%d", width, height, cfg.minVideoWidth, cfg.minVideoHeight), nil)
+		return
+	}
+
+	color, err := getVideoColorInfo(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error determining color information", err)
+		return
+	}
+
+	encoding, err := getVideoEncodingInfo(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error determining encoding information", err)
+		return
+	}
+
+	chapters, err := getVideoChapters(probe)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chapter metadata", err)
+		return
+	}
+
+	if durationErr != nil {
+		log.Printf("Couldn't determine duration for video %s: %v", videoID, durationErr)
+	}
+
+	bitrate, err := getVideoBitrate(probe)
+	if err != nil {
+		log.Printf("Couldn't determine bitrate for video %s: %v", videoID, err)
+	}
+
+	recordedAt, err := getVideoRecordedAt(probe)
+	if err != nil {
+		log.Printf("Couldn't determine recording time for video %s: %v", videoID, err)
+	}
+
+	aspectRatio, _, err := getVideoAspectRatio(probe)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
+	displayWidth, displayHeight, err := getVideoDisplayDimensions(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error determining display dimensions", err)
+		return
+	}
+	sampleAspectRatio, displayAspectRatio, err := getVideoAspectRatios(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		return
 	}
 
-	key := getAssetPath(mediaType)
-	key = filepath.Join(directory, key)
+	// The poster frame is stored before the slow steps so clients can show it
+	// while the video is still processing. Thumbnails the user uploaded are
+	// kept; generated ones follow the new source.
+	var staleThumbnailURL *string
+	regenerateThumbnail := cfg.regenerateAutoThumbnails && video.ThumbnailIsAuto && video.ThumbnailURL != nil
+	if (r.URL.Query().Get("autothumb") == "true" && video.ThumbnailURL == nil) || regenerateThumbnail {
+		thumbnailURL, thumbnailSize, err := cfg.createAutoThumbnail(r.Context(), bucket, tempFile.Name(), probe, userID, videoID)
+		if err != nil {
+			log.Printf("Couldn't generate thumbnail for video %s: %v", videoID, err)
+		} else {
+			if video.ThumbnailURL != nil && *video.ThumbnailURL != thumbnailURL {
+				staleThumbnailURL = video.ThumbnailURL
+			}
+			video.ThumbnailURL = &thumbnailURL
+			video.ThumbnailSize = thumbnailSize
+			video.ThumbnailIsAuto = true
+		}
+	}
 
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	video.ProcessingStatus = database.ProcessingStatusProcessing
+	video, err = cfg.db.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+		respondWithWriteError(w, "Couldn't update video", err)
 		return
 	}
-	defer os.Remove(processedFilePath)
+	defer func() {
+		if !progress.succeeded.Load() {
+			cfg.markProcessingFailed(videoID)
+		}
+	}()
+
+	if staleThumbnailURL != nil {
+		if err := cfg.removeAsset(r.Context(), *staleThumbnailURL); err != nil {
+			log.Printf("Couldn't remove previous thumbnail for video %s: %v", videoID, err)
+		}
+	}
+
+	processedFilePath, err := cfg.processVideoForFastStart(r.Context(), tempFile.Name())
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		// Without ffmpeg the upload is still usable, it just can't start
+		// playing before it has fully downloaded.
+		log.Printf("ffmpeg not found, uploading video %s without faststart", videoID)
+		processedFilePath = tempFile.Name()
+	case err != nil:
+		processingFailed = true
+		cfg.saveProcessingLog(videoID, err)
+		respondWithError(w, pipelineErrorStatus(err), "Error processing video", err)
+		return
+	default:
+		defer func() { cfg.cleanupTempFile(processedFilePath, videoID, processingFailed) }()
+	}
 
 	processedFile, err := os.Open(processedFilePath)
 	if err != nil {
@@ -118,87 +360,249 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer processedFile.Close()
+	processedInfo, err := processedFile.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read processed file", err)
+		return
+	}
 
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        processedFile,
-		ContentType: aws.String(mediaType),
-	})
+	var renditionFiles map[string]string
+	if r.URL.Query().Get("transcode") == "true" {
+		renditionFiles, err = cfg.transcodeRenditions(r.Context(), processedFilePath, height)
+		if err != nil {
+			processingFailed = true
+			cfg.saveProcessingLog(videoID, err)
+			respondWithError(w, pipelineErrorStatus(err), "Error transcoding video", err)
+			return
+		}
+		defer removeRenditionFiles(renditionFiles)
+	}
+
+	// The sheet is rendered before anything is uploaded, so an ffmpeg failure
+	// doesn't leave objects behind.
+	var contactSheetPath string
+	if cfg.contactSheetFrames > 0 {
+		contactSheetPath, err = cfg.renderContactSheet(r.Context(), processedFilePath, probe)
+		if err != nil {
+			processingFailed = true
+			cfg.saveProcessingLog(videoID, err)
+			respondWithError(w, pipelineErrorStatus(err), "Error generating contact sheet", err)
+			return
+		}
+		defer os.Remove(contactSheetPath)
+	}
+
+	// Renditions and the contact sheet are stored too, so the quota is checked
+	// again once their sizes are known.
+	renditionsSize, err := filesSize(slices.Collect(maps.Values(renditionFiles))...)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not read renditions", err)
+		return
+	}
+	contactSheetSize, err := filesSize(contactSheetPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read contact sheet", err)
+		return
+	}
+	overQuota, err := cfg.exceedsStorageQuota(userID, mediaSize(video), processedInfo.Size()+renditionsSize+contactSheetSize)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+	if overQuota {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload would exceed your storage quota", nil)
 		return
 	}
 
-	videoURL := cfg.getObjectURL(key)
-	video.VideoURL = &videoURL
-	err = cfg.db.UpdateVideo(video)
+	newKey := func() (string, error) {
+		return renderKeyTemplate(cfg.videoKeyTemplate, keyTemplateValues{
+			UserID:  userID,
+			VideoID: videoID,
+			Ext:     mediaTypeToExt(mediaType),
+			Aspect:  cfg.aspectRatioPrefix(aspectRatio),
+		})
+	}
+	key, err := cfg.uploadNewObjectToS3(r.Context(), bucket, func() (string, error) {
+		key, err := newKey()
+		if err != nil {
+			return "", err
+		}
+		return cfg.userKey(userID, key), nil
+	}, processedFile, mediaType, metadata)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		if uploadTimedOut(r, err) {
+			respondWithError(w, http.StatusRequestTimeout, "Upload took too long", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
+		return
+	}
+	if err := cfg.confirmObject(r.Context(), bucket, key); err != nil {
+		if delErr := cfg.deleteObject(context.Background(), bucket, key); delErr != nil {
+			log.Printf("Couldn't clean up unconfirmed object %s: %v", key, delErr)
+		}
+		respondWithError(w, pipelineErrorStatus(err), "Couldn't confirm upload to S3", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
-}
+	var renditions database.Renditions
+	if renditionFiles != nil {
+		renditions, err = cfg.uploadRenditions(r.Context(), bucket, userID, renditionFiles, newKey, metadata)
+		if err != nil {
+			if delErr := cfg.deleteObject(context.Background(), bucket, key); delErr != nil {
+				log.Printf("Couldn't clean up object %s: %v", key, delErr)
+			}
+			respondWithError(w, http.StatusInternalServerError, "Error uploading renditions to S3", err)
+			return
+		}
+	}
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
+	replaced := video
+	videoURL := cfg.storedVideoURL(bucket, key)
+	video.VideoURL = &videoURL
+	video.SHA256 = &checksum
+	video.OriginalSizeBytes = uploadedBytes
+	video.FileSize = processedInfo.Size()
+	video.Renditions = renditions
+	video.RenditionsSize = renditionsSize
+	video.Duration = duration
+	video.RecordedAt = recordedAt
+	video.Width = width
+	video.Height = height
+	video.SampleAspectRatio = sampleAspectRatio
+	video.DisplayAspectRatio = displayAspectRatio
+	video.Orientation = getVideoOrientation(displayWidth, displayHeight)
+	video.HasAudio = hasAudioStream(probe)
+	video.Bitrate = bitrate
+	video.PixFmt = color.PixFmt
+	video.ColorSpace = color.ColorSpace
+	video.ColorTransfer = color.ColorTransfer
+	video.ColorPrimaries = color.ColorPrimaries
+	video.CodecProfile = encoding.Profile
+	video.CodecLevel = encoding.Level
+	video.HasBFrames = encoding.HasBFrames
+	video.Chapters = chapters
+	video.ProcessingLog = nil
 
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+	// Anything failing from here on leaves the new objects unreferenced.
+	removeUploaded := func() {
+		uploaded := database.Video{VideoURL: &videoURL, Renditions: renditions}
+		if err := cfg.deleteVideoObjects(context.Background(), uploaded); err != nil {
+			log.Printf("Couldn't clean up uploaded files of video %s: %v", videoID, err)
+		}
+	}
+
+	if contactSheetPath != "" {
+		contactSheetURL, err := cfg.uploadContactSheet(r.Context(), bucket, contactSheetPath, userID, videoID)
+		if err != nil {
+			removeUploaded()
+			respondWithError(w, http.StatusInternalServerError, "Error uploading contact sheet", err)
+			return
+		}
+		video.ContactSheetURL = &contactSheetURL
+	} else {
+		// A sheet from an earlier upload would no longer match the video.
+		video.ContactSheetURL = nil
+	}
+	video.ContactSheetSize = contactSheetSize
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
+	video.ProcessingStatus = database.ProcessingStatusReady
+	// Only the file's columns are written; the row may have been read
+	// minutes ago.
+	video, err = cfg.db.SetVideoMedia(video)
+	if err != nil {
+		removeUploaded()
+		respondWithWriteError(w, "Couldn't update video", err)
+		return
 	}
+	progress.succeeded.Store(true)
 
-	var output struct {
-		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
+	// The replaced file and renditions are no longer referenced, and the
+	// quota only counts the current ones. A new contact sheet overwrote the
+	// old one in place, so that one must stay.
+	if replaced.ContactSheetURL != nil && video.ContactSheetURL != nil && *replaced.ContactSheetURL == *video.ContactSheetURL {
+		replaced.ContactSheetURL = nil
 	}
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+	if err := cfg.deleteVideoObjects(context.Background(), replaced); err != nil {
+		log.Printf("Couldn't remove replaced files of video %s: %v", videoID, err)
 	}
 
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
 	}
 
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
+	resp := videoResponse{Video: video}
+	if r.URL.Query().Get("includeKey") == "true" {
+		resp.Key = key
+	}
+	if r.URL.Query().Get("stats") == "true" {
+		stats := uploadStats{Bytes: uploadedBytes, Seconds: uploadSeconds}
+		if uploadSeconds > 0 {
+			stats.BytesPerSecond = float64(uploadedBytes) / uploadSeconds
+		}
+		resp.UploadStats = &stats
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
 
-	if width == 16*height/9 {
-		return "16:9", nil
-	} else if height == 16*width/9 {
-		return "9:16", nil
+func (cfg *apiConfig) markProcessingFailed(videoID uuid.UUID) {
+	if err := cfg.db.SetVideoProcessingStatus(videoID, database.ProcessingStatusFailed); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
 	}
-	return "other", nil
 }
 
-func processVideoForFastStart(inputFilePath string) (string, error) {
-	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+func (cfg *apiConfig) createAutoThumbnail(ctx context.Context, bucket, videoFilePath string, probe ffprobeOutput, userID, videoID uuid.UUID) (string, int64, error) {
+	atSeconds := autoThumbnailSeconds
+	if duration, err := getVideoDuration(probe); err == nil && duration < atSeconds {
+		atSeconds = duration / 2
+	}
 
-	cmd := exec.Command("ffmpeg", "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy", "-f", "mp4", processedFilePath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	thumbnailPath, err := cfg.generateThumbnailFromVideo(ctx, videoFilePath, atSeconds)
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(thumbnailPath)
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+	ext := filepath.Ext(thumbnailPath)
+	assetKey, err := renderKeyTemplate(cfg.thumbnailKeyTemplate, keyTemplateValues{
+		UserID:  userID,
+		VideoID: videoID,
+		Ext:     ext,
+	})
+	if err != nil {
+		return "", 0, err
 	}
 
-	fileInfo, err := os.Stat(processedFilePath)
+	thumbnail, err := os.Open(thumbnailPath)
 	if err != nil {
-		return "", fmt.Errorf("could not stat processed file: %v", err)
+		return "", 0, fmt.Errorf("could not open thumbnail: %v", err)
 	}
-	if fileInfo.Size() == 0 {
-		return "", fmt.Errorf("processed file is empty")
+	defer thumbnail.Close()
+
+	return cfg.storeThumbnail(ctx, bucket, userID, assetKey, thumbnail, mime.TypeByExtension(ext))
+}
+
+func (cfg *apiConfig) renderContactSheet(ctx context.Context, videoFilePath string, probe ffprobeOutput) (string, error) {
+	duration, err := getVideoDuration(probe)
+	if err != nil {
+		return "", err
+	}
+	return cfg.generateContactSheet(ctx, videoFilePath, duration, cfg.contactSheetFrames, cfg.contactSheetColumns, cfg.contactSheetRows)
+}
+
+func (cfg *apiConfig) uploadContactSheet(ctx context.Context, bucket, contactSheetPath string, userID, videoID uuid.UUID) (string, error) {
+	contactSheet, err := os.Open(contactSheetPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open contact sheet: %v", err)
 	}
+	defer contactSheet.Close()
 
-	return processedFilePath, nil
+	key := cfg.userKey(userID, fmt.Sprintf("contactsheets/%s.jpg", videoID))
+	err = cfg.uploadToS3(ctx, bucket, key, contactSheet, "image/jpeg")
+	if err != nil {
+		return "", err
+	}
+	return cfg.storedAssetURL(bucket, key), nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+)
+
+// videoPart is the "video" form file of an upload request. size is -1 when
+// the part is read straight off the request body and its length isn't known
+// until it has been copied.
+type videoPart struct {
+	io.ReadCloser
+	filename string
+	header   textproto.MIMEHeader
+	size     int64
+}
+
+// openVideoPart returns the "video" form file. Large requests skip
+// ParseMultipartForm, which would buffer the whole body to its own temp
+// file before the handler could copy it to ours.
+func openVideoPart(r *http.Request, large bool) (videoPart, error) {
+	if !large {
+		file, handler, err := r.FormFile("video")
+		if err != nil {
+			return videoPart{}, err
+		}
+		return videoPart{ReadCloser: file, filename: handler.Filename, header: handler.Header, size: handler.Size}, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return videoPart{}, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return videoPart{}, http.ErrMissingFile
+		}
+		if err != nil {
+			return videoPart{}, err
+		}
+		if part.FormName() != "video" {
+			part.Close()
+			continue
+		}
+		return videoPart{ReadCloser: part, filename: part.FileName(), header: part.Header, size: -1}, nil
+	}
+}
+
+// spoolUpload copies src to dst and returns the number of bytes copied and
+// their SHA-256. The hash is computed on the same pass as the copy, so the
+// upload is never read back just to checksum it.
+func spoolUpload(dst io.Writer, src io.Reader) (int64, string, error) {
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, hasher), src)
+	if err != nil {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(hasher.Sum(nil)), nil
+}
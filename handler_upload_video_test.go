@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// testMP4 returns data that sniffs as video/mp4. The fake ffprobe doesn't
+// read it.
+func testMP4(size int) []byte {
+	data := make([]byte, max(size, 32))
+	copy(data, "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	return data
+}
+
+// installFakeFFmpeg puts ffprobe and ffmpeg stand-ins first on PATH. ffprobe
+// reports a 10 second 1920x1080 video with audio; ffmpeg copies its input to
+// the output path, or fails when its arguments contain failOn.
+func installFakeFFmpeg(t *testing.T, failOn ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	failures := ""
+	for _, pattern := range failOn {
+		failures += fmt.Sprintf("case \"$*\" in *%s*) echo \"fake ffmpeg failed\" >&2; exit 1;; esac\n", pattern)
+	}
+	scripts := map[string]string{
+		"ffprobe": `#!/bin/sh
+echo '{"streams":[{"codec_type":"video","codec_name":"h264","width":1920,"height":1080},{"codec_type":"audio"}],"format":{"duration":"10.0","size":"1000"}}'
+`,
+		"ffmpeg": "#!/bin/sh\n" + failures + `in=""; prev=""
+for a in "$@"; do [ "$prev" = "-i" ] && in="$a"; prev="$a"; out="$a"; done
+cp "$in" "$out"
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// installFakeFFprobe replaces the fake ffprobe with one printing output.
+func installFakeFFprobe(t *testing.T, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat <<'EOF'\n" + output + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func newVideoUploadRequest(t *testing.T, videoID uuid.UUID, token, query string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="video.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID.String()+query, &body)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func uploadVideo(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token, query string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newVideoUploadRequest(t, videoID, token, query, data))
+	return w
+}
+
+func TestVideoUploadContactSheetFailureLeavesNoObjects(t *testing.T) {
+	installFakeFFmpeg(t, "tile=")
+	cfg := newTestConfig(t)
+	cfg.contactSheetFrames = 4
+	cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.VideoURL != nil || updated.SHA256 != nil || updated.FileSize != 0 {
+		t.Errorf("failed upload was saved: url=%v sha256=%v size=%d", updated.VideoURL, updated.SHA256, updated.FileSize)
+	}
+	if updated.ProcessingLog == nil || !strings.Contains(*updated.ProcessingLog, "fake ffmpeg failed") {
+		t.Errorf("processing log = %v, want the ffmpeg error", updated.ProcessingLog)
+	}
+	if updated.ProcessingStatus != database.ProcessingStatusFailed {
+		t.Errorf("processing status = %q, want %q", updated.ProcessingStatus, database.ProcessingStatusFailed)
+	}
+
+	err = filepath.WalkDir(cfg.assetsRoot, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("unexpected stored object %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVideoUploadLarge(t *testing.T) {
+	installFakeFFmpeg(t)
+
+	tests := []struct {
+		name     string
+		minWidth int
+		want     int
+	}{
+		{"probed and stored", 0, http.StatusOK},
+		{"below minimum width", 3840, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.multipartThreshold = 1
+			cfg.minVideoWidth = tt.minWidth
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+
+			updated, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != http.StatusOK {
+				if updated.VideoURL != nil {
+					t.Errorf("rejected upload was saved: %s", *updated.VideoURL)
+				}
+				return
+			}
+			if updated.Width != 1920 || updated.Height != 1080 || updated.Duration != 10 {
+				t.Errorf("probe wasn't recorded: %dx%d, %vs", updated.Width, updated.Height, updated.Duration)
+			}
+			if updated.VideoURL == nil || !strings.Contains(*updated.VideoURL, "/landscape/") {
+				t.Errorf("video URL = %v, want a landscape key", updated.VideoURL)
+			}
+		})
+	}
+}
+
+// onceReader fails if it's read again after returning EOF.
+type onceReader struct {
+	r    io.Reader
+	done bool
+}
+
+func (o *onceReader) Read(p []byte) (int, error) {
+	if o.done {
+		return 0, errors.New("upload read a second time")
+	}
+	n, err := o.r.Read(p)
+	if err == io.EOF {
+		o.done = true
+	}
+	return n, err
+}
+
+func TestSpoolUpload(t *testing.T) {
+	for _, size := range []int{0, 1, 512, 1 << 20} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := testMP4(size)[:size]
+			var dst bytes.Buffer
+			n, checksum, err := spoolUpload(&dst, &onceReader{r: bytes.NewReader(data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(data)
+			if want := hex.EncodeToString(sum[:]); checksum != want {
+				t.Errorf("checksum = %s, want %s", checksum, want)
+			}
+			if n != int64(size) || !bytes.Equal(dst.Bytes(), data) {
+				t.Errorf("copied %d bytes, want %d", n, size)
+			}
+		})
+	}
+}
+
+func BenchmarkSpoolUpload(b *testing.B) {
+	data := testMP4(64 << 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, _, err := spoolUpload(io.Discard, &onceReader{r: bytes.NewReader(data)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestVideoUploadRecordsChecksum(t *testing.T) {
+	installFakeFFmpeg(t)
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	data := testMP4(4096)
+	if w := uploadVideo(t, cfg, video.ID, token, "", data); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if want := hex.EncodeToString(sum[:]); updated.SHA256 == nil || *updated.SHA256 != want {
+		t.Errorf("sha256 = %v, want %s", updated.SHA256, want)
+	}
+}
+
+func TestVideoUploadAutoThumbnail(t *testing.T) {
+	installFakeFFmpeg(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"requested", "?autothumb=true", true},
+		{"not requested", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if w := uploadVideo(t, cfg, video.ID, token, tt.query, testMP4(1000)); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+			updated, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := assetExists(cfg, updated.ThumbnailURL); got != tt.want {
+				t.Errorf("thumbnail stored = %v, want %v (url %v)", got, tt.want, updated.ThumbnailURL)
+			}
+			if tt.want && updated.ThumbnailSize == 0 {
+				t.Error("thumbnail size wasn't recorded")
+			}
+		})
+	}
+}
+
+func TestGenerateThumbnailFromVideo(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	sample := filepath.Join(t.TempDir(), "sample.mp4")
+	err := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=2:size=320x240:rate=10", "-pix_fmt", "yuv420p", sample).Run()
+	if err != nil {
+		t.Fatalf("couldn't create sample video: %v", err)
+	}
+
+	cfg := &apiConfig{ffmpegMaxAttempts: 1}
+	thumbnailPath, err := cfg.generateThumbnailFromVideo(context.Background(), sample, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(thumbnailPath)
+
+	thumbnail, err := os.Open(thumbnailPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thumbnail.Close()
+	img, err := jpeg.Decode(thumbnail)
+	if err != nil {
+		t.Fatalf("thumbnail isn't a JPEG: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 320 || bounds.Dy() != 240 {
+		t.Errorf("thumbnail is %dx%d, want 320x240", bounds.Dx(), bounds.Dy())
+	}
+}
+
+// pauseFFmpegAt wraps the fake ffmpeg so a run whose arguments contain
+// pattern waits until release is called. The returned channel is closed
+// once such a run has started.
+func pauseFFmpegAt(t *testing.T, pattern string) (started <-chan struct{}, release func()) {
+	t.Helper()
+	fake, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	script := fmt.Sprintf(`#!/bin/sh
+case "$*" in *%s*) touch %[2]s/started; while [ ! -f %[2]s/release ]; do sleep 0.01; done;; esac
+exec %[3]s "$@"
+`, pattern, dir, fake)
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	startedCh := make(chan struct{})
+	go func() {
+		for {
+			if _, err := os.Stat(filepath.Join(dir, "started")); err == nil {
+				close(startedCh)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	release = func() {
+		os.WriteFile(filepath.Join(dir, "release"), nil, 0644)
+	}
+	t.Cleanup(release)
+	return startedCh, release
+}
+
+func TestVideoUploadThumbnailBeforeReady(t *testing.T) {
+	installFakeFFmpeg(t)
+	started, release := pauseFFmpegAt(t, "movflags")
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		cfg.handlerUploadVideo(w, newVideoUploadRequest(t, video.ID, token, "?autothumb=true", testMP4(1000)))
+		done <- w
+	}()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload never reached faststart")
+	}
+
+	processing, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processing.ProcessingStatus != database.ProcessingStatusProcessing {
+		t.Errorf("status while processing = %q, want %q", processing.ProcessingStatus, database.ProcessingStatusProcessing)
+	}
+	if !assetExists(cfg, processing.ThumbnailURL) {
+		t.Errorf("thumbnail wasn't stored before processing finished: %v", processing.ThumbnailURL)
+	}
+
+	// A change made while the upload is processing survives its final write.
+	processing.Title = "Renamed"
+	if _, err := cfg.db.UpdateVideo(processing); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	ready, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.ProcessingStatus != database.ProcessingStatusReady || ready.VideoURL == nil {
+		t.Errorf("upload didn't finish: status %q, url %v", ready.ProcessingStatus, ready.VideoURL)
+	}
+	if ready.Title != "Renamed" {
+		t.Errorf("title = %q, the upload overwrote it", ready.Title)
+	}
+}
+
+func TestVideoReuploadKeepsContactSheet(t *testing.T) {
+	installFakeFFmpeg(t)
+	cfg := newTestConfig(t)
+	cfg.contactSheetFrames = 4
+	cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	for i := 0; i < 2; i++ {
+		if w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000)); w.Code != http.StatusOK {
+			t.Fatalf("upload %d: expected 200, got %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assetExists(cfg, updated.ContactSheetURL) {
+		t.Errorf("contact sheet %v was removed with the replaced video", updated.ContactSheetURL)
+	}
+}
+
+func TestVideoUploadStoresAssetsInRequestBucket(t *testing.T) {
+	installFakeFFmpeg(t)
+	fake := &fakeS3{existing: map[string]bool{}}
+	cfg := newFakeS3Config(t, fake)
+	cfg.trustBucketHeader = true
+	cfg.bucketAllowlist = []string{"tubely-archive"}
+	cfg.contactSheetFrames = 4
+	cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	req := newVideoUploadRequest(t, video.ID, token, "?autothumb=true", testMP4(1000))
+	req.Header.Set(storageBucketHeader, "tubely-archive")
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	if len(fake.puts) != 3 {
+		t.Fatalf("got puts %v, want the video, thumbnail and contact sheet", fake.puts)
+	}
+	for _, put := range fake.puts {
+		if !strings.HasPrefix(put, "/tubely-archive/") {
+			t.Errorf("%s was stored outside the requested bucket", put)
+		}
+	}
+
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, storedURL := range map[string]*string{"thumbnail": updated.ThumbnailURL, "contact sheet": updated.ContactSheetURL} {
+		if storedURL == nil || !strings.HasPrefix(*storedURL, "tubely-archive,") {
+			t.Errorf("%s URL = %v, want a tubely-archive object", name, storedURL)
+		}
+	}
+	if !strings.Contains(w.Body.String(), "X-Amz-Signature") {
+		t.Errorf("response URLs aren't signed: %s", w.Body)
+	}
+}
+
+func TestVideoUploadRejectsEncryptedMedia(t *testing.T) {
+	tests := []struct {
+		name     string
+		codecTag string
+		want     int
+	}{
+		{"encrypted", "encv", http.StatusUnprocessableEntity},
+		{"clear", "avc1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeFFmpeg(t)
+			installFakeFFprobe(t, fmt.Sprintf(`{"streams":[{"codec_type":"video","codec_name":"h264","codec_tag_string":%q,"width":1920,"height":1080}],"format":{"duration":"10.0"}}`, tt.codecTag))
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if tt.want == http.StatusUnprocessableEntity && !strings.Contains(strings.ToLower(w.Body.String()), "encrypted media not supported") {
+				t.Errorf("unexpected error message: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestVideoUploadRecordsOriginalSize(t *testing.T) {
+	installFakeFFmpeg(t)
+	// Processing grows the file, so the stored size differs from the upload.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+in=""; prev=""
+for a in "$@"; do [ "$prev" = "-i" ] && in="$a"; prev="$a"; out="$a"; done
+cp "$in" "$out"; printf 'processed' >> "$out"
+`
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, size := range []int{1000, 70000} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if w := uploadVideo(t, cfg, video.ID, token, "", testMP4(size)); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+			w := getVideoMeta(cfg, video, "", token, "")
+			var meta database.Video
+			if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.OriginalSizeBytes != int64(size) {
+				t.Errorf("original_size_bytes = %d, want %d", meta.OriginalSizeBytes, size)
+			}
+			if meta.FileSize == meta.OriginalSizeBytes {
+				t.Errorf("file_size = %d, want the processed size to differ from the upload", meta.FileSize)
+			}
+		})
+	}
+}
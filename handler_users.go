@@ -38,9 +38,47 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		Password: hashedPassword,
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+		respondWithWriteError(w, "Couldn't create user", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+func (cfg *apiConfig) handlerUsersUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DefaultVisibility string `json:"default_visibility"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	if !database.ValidVisibility(params.DefaultVisibility) {
+		respondWithError(w, http.StatusBadRequest, "Invalid visibility", nil)
+		return
+	}
+
+	user, err := cfg.db.UpdateUserDefaultVisibility(userID, params.DefaultVisibility)
+	if err != nil {
+		respondWithWriteError(w, "Couldn't update user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
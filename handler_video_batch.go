@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxVideoBatchSize = 100
+
+func (cfg *apiConfig) handlerVideosBatch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.IDs) > maxVideoBatchSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Batch may contain at most %d IDs", maxVideoBatchSize), nil)
+		return
+	}
+
+	var requesterID uuid.UUID
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, cfg.jwtSecret); err == nil {
+			requesterID = userID
+		}
+	}
+
+	// IDs that don't exist, aren't visible to the requester or couldn't be
+	// read are reported together, so private videos aren't revealed.
+	videos := []database.Video{}
+	missing := []uuid.UUID{}
+	seen := make(map[uuid.UUID]bool, len(params.IDs))
+	for _, id := range params.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		video, err := cfg.db.GetVideo(id)
+		if err != nil {
+			log.Printf("Couldn't get video %s for batch: %v", id, err)
+			missing = append(missing, id)
+			continue
+		}
+		if video.ID == uuid.Nil || video.Visibility == database.VisibilityPrivate && video.UserID != requesterID {
+			missing = append(missing, id)
+			continue
+		}
+		videos = append(videos, video)
+	}
+
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	type response struct {
+		Videos  []database.Video `json:"videos"`
+		Missing []uuid.UUID      `json:"missing"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Videos:  videos,
+		Missing: missing,
+	})
+}
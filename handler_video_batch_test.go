@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideosBatchMixed(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+
+	public := createTestVideo(t, cfg, ownerID, database.VisibilityPublic)
+	private := createTestVideo(t, cfg, ownerID, database.VisibilityPrivate)
+	unknown := uuid.New()
+
+	tests := []struct {
+		name        string
+		token       string
+		wantVideos  []uuid.UUID
+		wantMissing []uuid.UUID
+	}{
+		{"owner", ownerToken, []uuid.UUID{public.ID, private.ID}, []uuid.UUID{unknown}},
+		{"other user", otherToken, []uuid.UUID{public.ID}, []uuid.UUID{private.ID, unknown}},
+		{"anonymous", "", []uuid.UUID{public.ID}, []uuid.UUID{private.ID, unknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"ids":["` + public.ID.String() + `","` + private.ID.String() + `","` + unknown.String() + `"]}`
+			req := httptest.NewRequest(http.MethodPost, "/api/videos/batch", strings.NewReader(body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			cfg.handlerVideosBatch(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+
+			var resp struct {
+				Videos  []database.Video `json:"videos"`
+				Missing []uuid.UUID      `json:"missing"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			gotVideos := []uuid.UUID{}
+			for _, video := range resp.Videos {
+				gotVideos = append(gotVideos, video.ID)
+			}
+			if !equalIDs(gotVideos, tt.wantVideos) {
+				t.Errorf("videos = %v, want %v", gotVideos, tt.wantVideos)
+			}
+			if !equalIDs(resp.Missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", resp.Missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func equalIDs(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
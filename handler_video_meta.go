@@ -2,13 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+const (
+	defaultVideoPageSize = 20
+	maxVideoPageSize     = 100
+)
+
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
@@ -34,9 +42,25 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	}
 	params.UserID = userID
 
+	if params.Visibility == "" {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		params.Visibility = database.VisibilityPublic
+		if user != nil && user.DefaultVisibility != "" {
+			params.Visibility = user.DefaultVisibility
+		}
+	}
+	if !database.ValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Invalid visibility", nil)
+		return
+	}
+
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		respondWithWriteError(w, "Couldn't create video", err)
 		return
 	}
 
@@ -62,6 +86,12 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !cfg.processingLocks.tryLock(videoID) {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return
+	}
+	defer cfg.processingLocks.unlock(videoID)
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
@@ -72,12 +102,29 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	err = cfg.deleteVideoObjects(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video files", err)
+		return
+	}
+
 	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithWriteError(w, "Couldn't delete video", err)
 		return
 	}
 
+	if cfg.deleteThumbnailOnVideoDelete {
+		for _, thumbnailURL := range []*string{video.ThumbnailURL, video.PreviousThumbnailURL} {
+			if thumbnailURL == nil {
+				continue
+			}
+			if err := cfg.removeAsset(r.Context(), *thumbnailURL); err != nil {
+				log.Printf("Couldn't remove thumbnail for deleted video %s: %v", videoID, err)
+			}
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -94,8 +141,57 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.Visibility == database.VisibilityPrivate && !cfg.isRequestFromUser(r, video.UserID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	includeKey := r.URL.Query().Get("includeKey") == "true" && cfg.isRequestFromUser(r, video.UserID)
+	variant := ""
+	if includeKey {
+		variant = "key"
+	}
+	if cfg.setVideoCacheHeaders(w, r, video, variant) {
+		return
+	}
+	resp := videoResponse{}
+	if includeKey {
+		_, resp.Key, _ = cfg.videoObject(video)
+	}
+	resp.Video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerVideosPublic(w http.ResponseWriter, r *http.Request) {
+	videos, err := cfg.db.GetPublicVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) isRequestFromUser(r *http.Request, userID uuid.UUID) bool {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return false
+	}
+	requestUserID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return false
+	}
+	return requestUserID == userID
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -110,11 +206,58 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	limit, err := queryInt(r, "limit", defaultVideoPageSize)
+	if err != nil || limit < 1 || limit > maxVideoPageSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxVideoPageSize), err)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "offset must not be negative", err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideosByUser(userID, limit, offset)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	total, err := cfg.db.CountVideosByUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count videos", err)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	// next_cursor is the offset of the following page, null on the last one.
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		Total      int              `json:"total"`
+		Limit      int              `json:"limit"`
+		Offset     int              `json:"offset"`
+		NextCursor *int             `json:"next_cursor"`
+	}
+	resp := response{
+		Videos: videos,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if next := offset + len(videos); len(videos) > 0 && next < total {
+		resp.NextCursor = &next
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
 }
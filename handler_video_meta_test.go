@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestDeleteVideoRemovesObjects(t *testing.T) {
+	const (
+		videoKey        = "videos/a.mp4"
+		renditionKey    = "videos/a-480p.mp4"
+		contactSheetKey = "contactsheets/a.jpg"
+	)
+
+	tests := []struct {
+		name     string
+		existing []string
+		strict   bool
+	}{
+		{"all objects present", []string{videoKey, renditionKey, contactSheetKey}, false},
+		{"contact sheet already gone", []string{videoKey, renditionKey}, false},
+		{"NoSuchKey counts as deleted", []string{videoKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{}, strictDeletes: tt.strict}
+			for _, key := range tt.existing {
+				fake.existing["/tubely-test/"+key] = true
+			}
+			cfg := newFakeS3Config(t, fake)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+			videoURL := cfg.storedVideoURL(cfg.s3Bucket, videoKey)
+			contactSheetURL := cfg.getObjectURL(contactSheetKey)
+			video.VideoURL = &videoURL
+			video.ContactSheetURL = &contactSheetURL
+			video.Renditions = map[string]string{"480p": cfg.storedVideoURL(cfg.s3Bucket, renditionKey)}
+			if _, err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+
+			if w := deleteVideo(cfg, video.ID, token); w.Code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d: %s", w.Code, w.Body)
+			}
+
+			for _, key := range []string{videoKey, renditionKey, contactSheetKey} {
+				if !slices.Contains(fake.deletes, "/tubely-test/"+key) {
+					t.Errorf("%s wasn't deleted; deletes = %v", key, fake.deletes)
+				}
+			}
+			if len(fake.existing) != 0 {
+				t.Errorf("objects left behind: %v", fake.existing)
+			}
+			if deleted, err := cfg.db.GetVideo(video.ID); err != nil || deleted.ID == video.ID {
+				t.Errorf("video row still exists: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteVideoRemovesThumbnail(t *testing.T) {
+	const thumbnailKey = "thumbnails/a.png"
+
+	tests := []struct {
+		name    string
+		s3      bool
+		present bool
+		enabled bool
+	}{
+		{"local", false, true, true},
+		{"local already missing", false, false, true},
+		{"local disabled", false, true, false},
+		{"s3", true, true, true},
+		{"s3 already missing", true, false, true},
+		{"s3 disabled", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{}, strictDeletes: true}
+			cfg := newTestConfig(t)
+			if tt.s3 {
+				cfg = newFakeS3Config(t, fake)
+			}
+			cfg.deleteThumbnailOnVideoDelete = tt.enabled
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			var exists func() bool
+			if tt.s3 {
+				thumbnailURL := cfg.storedAssetURL(cfg.s3Bucket, thumbnailKey)
+				video.ThumbnailURL = &thumbnailURL
+				if _, err := cfg.db.UpdateVideo(video); err != nil {
+					t.Fatal(err)
+				}
+				if tt.present {
+					fake.existing["/tubely-test/"+thumbnailKey] = true
+				}
+				exists = func() bool { return fake.existing["/tubely-test/"+thumbnailKey] }
+			} else {
+				video = createLocalThumbnail(t, cfg, video, "a.png", 0)
+				if !tt.present {
+					if err := os.Remove(cfg.getAssetDiskPath(cfg.userKey(userID, "a.png"))); err != nil {
+						t.Fatal(err)
+					}
+				}
+				exists = func() bool { return assetExists(cfg, video.ThumbnailURL) }
+			}
+
+			if w := deleteVideo(cfg, video.ID, token); w.Code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d: %s", w.Code, w.Body)
+			}
+			if want := tt.present && !tt.enabled; exists() != want {
+				t.Errorf("thumbnail exists = %v, want %v", exists(), want)
+			}
+		})
+	}
+}
+
+type videoPage struct {
+	Videos     []database.Video `json:"videos"`
+	Total      int              `json:"total"`
+	NextCursor *int             `json:"next_cursor"`
+}
+
+func listVideos(t *testing.T, cfg *apiConfig, token, query string) (*httptest.ResponseRecorder, videoPage) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/videos"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.handlerVideosRetrieve(w, req)
+	var page videoPage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, page
+}
+
+func TestVideosRetrievePageBounds(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"?limit=1", http.StatusOK},
+		{fmt.Sprintf("?limit=%d", maxVideoPageSize), http.StatusOK},
+		{fmt.Sprintf("?limit=%d", maxVideoPageSize+1), http.StatusBadRequest},
+		{"?limit=0", http.StatusBadRequest},
+		{"?limit=ten", http.StatusBadRequest},
+		{"?offset=0", http.StatusOK},
+		{"?offset=-1", http.StatusBadRequest},
+		{"?offset=five", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w, _ := listVideos(t, cfg, token, tt.query); w.Code != tt.want {
+			t.Errorf("GET /api/videos%s: expected %d, got %d: %s", tt.query, tt.want, w.Code, w.Body)
+		}
+	}
+}
+
+func TestVideosRetrieveFollowsCursor(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	otherID, _ := createTestUser(t, cfg, "other@example.com")
+	createTestVideo(t, cfg, otherID, database.VisibilityPublic)
+	want := map[uuid.UUID]bool{}
+	for i := 0; i < 5; i++ {
+		want[createTestVideo(t, cfg, userID, database.VisibilityPublic).ID] = true
+	}
+
+	seen := map[uuid.UUID]bool{}
+	var cursors []int
+	cursor := 0
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatal("cursor never ran out")
+		}
+		w, page := listVideos(t, cfg, token, fmt.Sprintf("?limit=2&offset=%d", cursor))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		if page.Total != len(want) {
+			t.Errorf("total = %d, want %d", page.Total, len(want))
+		}
+		for _, video := range page.Videos {
+			if seen[video.ID] || !want[video.ID] {
+				t.Errorf("unexpected or repeated video %s", video.ID)
+			}
+			seen[video.ID] = true
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		cursor = *page.NextCursor
+		cursors = append(cursors, cursor)
+	}
+	if len(seen) != len(want) {
+		t.Errorf("saw %d of %d videos", len(seen), len(want))
+	}
+	if !slices.Equal(cursors, []int{2, 4}) {
+		t.Errorf("cursors = %v, want [2 4]", cursors)
+	}
+
+	if _, page := listVideos(t, cfg, token, "?offset=10"); len(page.Videos) != 0 || page.NextCursor != nil {
+		t.Errorf("page past the end = %d videos, cursor %v", len(page.Videos), page.NextCursor)
+	}
+}
+
+func TestVideoGetTimestampsAreRFC3339(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, _ := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	w := getVideoMeta(cfg, video, "", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"created_at", "updated_at"} {
+		value, _ := body[field].(string)
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			t.Errorf("%s = %q isn't RFC3339: %v", field, value, err)
+		}
+	}
+}
+
+func TestVideoVisibility(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+
+	tests := []struct {
+		visibility string
+		listed     bool
+		ownerGet   int
+		otherGet   int
+		anonGet    int
+	}{
+		{database.VisibilityPublic, true, http.StatusOK, http.StatusOK, http.StatusOK},
+		{database.VisibilityUnlisted, false, http.StatusOK, http.StatusOK, http.StatusOK},
+		{database.VisibilityPrivate, false, http.StatusOK, http.StatusNotFound, http.StatusNotFound},
+	}
+	videos := map[string]database.Video{}
+	for _, tt := range tests {
+		videos[tt.visibility] = createTestVideo(t, cfg, ownerID, tt.visibility)
+	}
+
+	w := httptest.NewRecorder()
+	cfg.handlerVideosPublic(w, httptest.NewRequest(http.MethodGet, "/api/videos/public", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("public listing: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var listed []database.Video
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.visibility, func(t *testing.T) {
+			video := videos[tt.visibility]
+			inListing := slices.ContainsFunc(listed, func(v database.Video) bool { return v.ID == video.ID })
+			if inListing != tt.listed {
+				t.Errorf("in public listing = %v, want %v", inListing, tt.listed)
+			}
+			for _, get := range []struct {
+				who   string
+				token string
+				want  int
+			}{
+				{"owner", ownerToken, tt.ownerGet},
+				{"other user", otherToken, tt.otherGet},
+				{"anonymous", "", tt.anonGet},
+			} {
+				if w := getVideoMeta(cfg, video, "", get.token, ""); w.Code != get.want {
+					t.Errorf("%s fetching directly: expected %d, got %d", get.who, get.want, w.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	thumbnailAspectCrop = "crop"
+	thumbnailAspectPad  = "pad"
+)
+
+const (
+	thumbnailReplaceOverwrite = "overwrite"
+	thumbnailReplaceReject    = "reject"
+	thumbnailReplaceKeep      = "keep"
+)
+
+// fittedSize returns the dimensions fitToAspectRatio gives a width x height
+// image, so callers can check them before anything is allocated.
+func fittedSize(width, height, ratioWidth, ratioHeight int, mode string) (int, int) {
+	wider := width*ratioHeight > height*ratioWidth
+	switch mode {
+	case thumbnailAspectCrop:
+		if wider {
+			return height * ratioWidth / ratioHeight, height
+		}
+		return width, width * ratioHeight / ratioWidth
+	case thumbnailAspectPad:
+		if wider {
+			return width, width * ratioHeight / ratioWidth
+		}
+		return height * ratioWidth / ratioHeight, height
+	default:
+		return width, height
+	}
+}
+
+func fitToAspectRatio(img image.Image, ratioWidth, ratioHeight int, mode string) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	fitWidth, fitHeight := fittedSize(width, height, ratioWidth, ratioHeight, mode)
+
+	switch mode {
+	case thumbnailAspectCrop:
+		x := bounds.Min.X + (width-fitWidth)/2
+		y := bounds.Min.Y + (height-fitHeight)/2
+		dst := image.NewRGBA(image.Rect(0, 0, fitWidth, fitHeight))
+		draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
+		return dst
+	case thumbnailAspectPad:
+		dst := image.NewRGBA(image.Rect(0, 0, fitWidth, fitHeight))
+		draw.Draw(dst, dst.Bounds(), image.Black, image.Point{}, draw.Src)
+		offset := image.Pt((fitWidth-width)/2, (fitHeight-height)/2)
+		draw.Draw(dst, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Src)
+		return dst
+	default:
+		return img
+	}
+}
+
+// imageTooLarge reports whether decoding a width x height image would go
+// over the configured limits. A zero limit disables that check.
+func (cfg *apiConfig) imageTooLarge(width, height int) bool {
+	if cfg.maxImageSourceDimension > 0 && max(width, height) > cfg.maxImageSourceDimension {
+		return true
+	}
+	return cfg.maxImageSourcePixels > 0 && int64(width)*int64(height) > cfg.maxImageSourcePixels
+}
+
+func resizeImage(src image.Image, maxDim int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDim <= 0 || max(width, height) <= maxDim {
+		return src
+	}
+
+	newWidth, newHeight := maxDim, max(1, height*maxDim/width)
+	if height > width {
+		newWidth, newHeight = max(1, width*maxDim/height), maxDim
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func averageColorHex(img image.Image) string {
+	const maxSamples = 100
+	bounds := img.Bounds()
+	step := max(1, max(bounds.Dx(), bounds.Dy())/maxSamples)
+
+	var r, g, b, n uint64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			r += uint64(pr >> 8)
+			g += uint64(pg >> 8)
+			b += uint64(pb >> 8)
+			n++
+		}
+	}
+	if n == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r/n, g/n, b/n)
+}
+
+const paletteSize = 5
+
+func extractPalette(img image.Image, size int) []string {
+	const maxSamples = 100
+	bounds := img.Bounds()
+	step := max(1, max(bounds.Dx(), bounds.Dy())/maxSamples)
+
+	type bucket struct {
+		r, g, b, n uint64
+	}
+	buckets := map[uint32]*bucket{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			r, g, b := pr>>8, pg>>8, pb>>8
+			key := (r>>5)<<6 | (g>>5)<<3 | b>>5
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r += uint64(r)
+			bk.g += uint64(g)
+			bk.b += uint64(b)
+			bk.n++
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].n != sorted[j].n {
+			return sorted[i].n > sorted[j].n
+		}
+		return sorted[i].r+sorted[i].g+sorted[i].b > sorted[j].r+sorted[j].g+sorted[j].b
+	})
+
+	palette := []string{}
+	for _, bk := range sorted[:min(size, len(sorted))] {
+		palette = append(palette, fmt.Sprintf("#%02x%02x%02x", bk.r/bk.n, bk.g/bk.n, bk.b/bk.n))
+	}
+	return palette
+}
+
+// validateJPEGQuality accepts the range image/jpeg.Options supports.
+func validateJPEGQuality(quality int) error {
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("quality %d must be between 1 and 100", quality)
+	}
+	return nil
+}
+
+func encodeImage(w io.Writer, img image.Image, mediaType string, jpegQuality int) error {
+	switch mediaType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("unsupported image type %s", mediaType)
+	}
+}
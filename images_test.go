@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// noisyImage has enough detail that JPEG quality visibly changes the size.
+func noisyImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 7), G: uint8(y * 13), B: uint8(x * y), A: 255})
+		}
+	}
+	return img
+}
+
+func TestResizeImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		maxDim        int
+		wantW, wantH  int
+	}{
+		{"landscape", 4000, 3000, 1280, 1280, 960},
+		{"portrait", 3000, 4000, 1280, 960, 1280},
+		{"square", 2000, 2000, 1280, 1280, 1280},
+		{"already small", 800, 600, 1280, 800, 600},
+		{"exactly at the cap", 1280, 720, 1280, 1280, 720},
+		{"thin strip", 5000, 2, 1280, 1280, 1},
+		{"disabled", 4000, 3000, 0, 4000, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			bounds := resizeImage(src, tt.maxDim).Bounds()
+			if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+				t.Errorf("resized to %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestAverageColorHex(t *testing.T) {
+	tests := []struct {
+		name  string
+		color color.Color
+		size  int
+		want  string
+	}{
+		{"red", color.RGBA{R: 255, A: 255}, 10, "#ff0000"},
+		{"teal", color.RGBA{G: 128, B: 128, A: 255}, 10, "#008080"},
+		{"white", color.White, 1, "#ffffff"},
+		{"large sampled", color.RGBA{R: 18, G: 52, B: 86, A: 255}, 2000, "#123456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, tt.size, tt.size))
+			draw.Draw(img, img.Bounds(), image.NewUniform(tt.color), image.Point{}, draw.Src)
+			if got := averageColorHex(img); got != tt.want {
+				t.Errorf("averageColorHex() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitToAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		ratioW        int
+		ratioH        int
+		mode          string
+		wantW, wantH  int
+	}{
+		{"crop square to 16:9", 160, 160, 1920, 1080, thumbnailAspectCrop, 160, 90},
+		{"pad square to 16:9", 160, 160, 1920, 1080, thumbnailAspectPad, 284, 160},
+		{"crop square to 9:16", 160, 160, 1080, 1920, thumbnailAspectCrop, 90, 160},
+		{"pad square to 9:16", 160, 160, 1080, 1920, thumbnailAspectPad, 160, 284},
+		{"crop ultrawide to 4:3", 400, 100, 640, 480, thumbnailAspectCrop, 133, 100},
+		{"pad ultrawide to 4:3", 400, 100, 640, 480, thumbnailAspectPad, 400, 300},
+		{"already matching", 320, 180, 1920, 1080, thumbnailAspectCrop, 320, 180},
+		{"disabled", 160, 160, 1920, 1080, "", 160, 160},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			draw.Draw(src, src.Bounds(), &image.Uniform{color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+
+			dst := fitToAspectRatio(src, tt.ratioW, tt.ratioH, tt.mode)
+			bounds := dst.Bounds()
+			if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+				t.Fatalf("got %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			}
+			if w, h := fittedSize(tt.width, tt.height, tt.ratioW, tt.ratioH, tt.mode); w != tt.wantW || h != tt.wantH {
+				t.Errorf("fittedSize() = %dx%d, want %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+
+			// The source stays centered; padding is black.
+			if r, _, _, _ := dst.At(bounds.Dx()/2, bounds.Dy()/2).RGBA(); r == 0 {
+				t.Error("center isn't from the source image")
+			}
+			padded := tt.mode == thumbnailAspectPad && (tt.wantW != tt.width || tt.wantH != tt.height)
+			if r, _, _, _ := dst.At(0, 0).RGBA(); (r == 0) != padded {
+				t.Errorf("corner padded = %v, want %v", r == 0, padded)
+			}
+		})
+	}
+}
+
+func TestValidateJPEGQuality(t *testing.T) {
+	tests := []struct {
+		quality int
+		wantErr bool
+	}{
+		{0, true},
+		{1, false},
+		{75, false},
+		{100, false},
+		{101, true},
+		{-5, true},
+	}
+	for _, tt := range tests {
+		if err := validateJPEGQuality(tt.quality); (err != nil) != tt.wantErr {
+			t.Errorf("validateJPEGQuality(%d) error = %v, wantErr %v", tt.quality, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEncodeImageJPEGQuality(t *testing.T) {
+	img := noisyImage(64, 64)
+	sizes := map[int]int{}
+	for _, quality := range []int{10, 95} {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, "image/jpeg", quality); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("quality %d: output doesn't decode: %v", quality, err)
+		}
+		sizes[quality] = buf.Len()
+	}
+	if sizes[10] >= sizes[95] {
+		t.Errorf("quality 10 gave %d bytes, quality 95 gave %d; want the lower quality to be smaller", sizes[10], sizes[95])
+	}
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+type Chapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+type Chapters []Chapter
+
+func (c Chapters) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (c *Chapters) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	default:
+		return fmt.Errorf("cannot scan %T into Chapters", src)
+	}
+}
@@ -2,9 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
@@ -25,6 +26,11 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+func IsReadOnlyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrReadonly
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,6 +78,114 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	type column struct {
+		name       string
+		definition string
+	}
+
+	refreshTokenColumns := []column{
+		{"hashed", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, column := range refreshTokenColumns {
+		err = c.addColumnIfNotExists("refresh_tokens", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+	// Refresh tokens used to be stored as issued. Lookups now hash the
+	// presented token, so those rows can never match again; drop them and
+	// their users sign in once more.
+	_, err = c.db.Exec("DELETE FROM refresh_tokens WHERE hashed = 0")
+	if err != nil {
+		return fmt.Errorf("failed to remove unhashed refresh tokens: %w", err)
+	}
+
+	userColumns := []column{
+		{"default_visibility", "TEXT NOT NULL DEFAULT 'public'"},
+	}
+	for _, column := range userColumns {
+		err = c.addColumnIfNotExists("users", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	videoColumns := []column{
+		{"contact_sheet_url", "TEXT"},
+		{"sha256", "TEXT"},
+		{"width", "INTEGER NOT NULL DEFAULT 0"},
+		{"height", "INTEGER NOT NULL DEFAULT 0"},
+		{"visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"dominant_color", "TEXT"},
+		{"processing_log", "TEXT"},
+		{"bitrate", "INTEGER NOT NULL DEFAULT 0"},
+		{"pix_fmt", "TEXT"},
+		{"color_space", "TEXT"},
+		{"color_transfer", "TEXT"},
+		{"color_primaries", "TEXT"},
+		{"chapters", "TEXT"},
+		{"orientation", "TEXT NOT NULL DEFAULT ''"},
+		{"has_audio", "INTEGER NOT NULL DEFAULT 0"},
+		{"palette", "TEXT"},
+		{"sample_aspect_ratio", "TEXT"},
+		{"display_aspect_ratio", "TEXT"},
+		{"original_size_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"duration", "REAL NOT NULL DEFAULT 0"},
+		{"recorded_at", "TIMESTAMP"},
+		{"file_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"renditions_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"contact_sheet_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"thumbnail_is_auto", "INTEGER NOT NULL DEFAULT 0"},
+		{"renditions", "TEXT"},
+		{"codec_profile", "TEXT"},
+		{"codec_level", "TEXT"},
+		{"has_b_frames", "INTEGER NOT NULL DEFAULT 0"},
+		{"processing_status", "TEXT NOT NULL DEFAULT ''"},
+		{"previous_thumbnail_url", "TEXT"},
+		{"thumbnail_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"previous_thumbnail_size", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, column := range videoColumns {
+		err = c.addColumnIfNotExists("videos", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,384 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestClient(t *testing.T) (Client, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, path
+}
+
+func TestMigrateDropsUnhashedRefreshTokens(t *testing.T) {
+	c, path := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.db.Exec(`
+		INSERT INTO refresh_tokens (token, user_id, expires_at, hashed)
+		VALUES ('legacy', ?, ?, 0)
+	`, user.ID.String(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateRefreshToken(CreateRefreshTokenParams{Token: "hashed", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt, _ := c.GetRefreshToken("legacy"); rt.Token != "" {
+		t.Error("unhashed refresh token survived the migration")
+	}
+	if rt, _ := c.GetRefreshToken("hashed"); rt.Token == "" {
+		t.Error("hashed refresh token was removed")
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if _, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := c.RotateRefreshToken("first", CreateRefreshTokenParams{Token: "second", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.ID {
+		t.Errorf("user = %s, want %s", userID, user.ID)
+	}
+	if rt, _ := c.GetRefreshToken("first"); rt.RevokedAt == nil {
+		t.Error("rotated token wasn't revoked")
+	}
+
+	_, err = c.RotateRefreshToken("first", CreateRefreshTokenParams{Token: "third", ExpiresAt: expiresAt})
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("reusing a rotated token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if rt, _ := c.GetRefreshToken("third"); rt.Token != "" {
+		t.Error("a replacement was stored for a reused token")
+	}
+
+	_, err = c.RotateRefreshToken("unknown", CreateRefreshTokenParams{Token: "fourth", UserID: uuid.New(), ExpiresAt: expiresAt})
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestSearchVideos(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.CreateUser(CreateUserParams{Email: "other@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(userID uuid.UUID, title, description string) uuid.UUID {
+		t.Helper()
+		video, err := c.CreateVideo(CreateVideoParams{Title: title, Description: description, UserID: userID, Visibility: VisibilityPublic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video.ID
+	}
+	cats := create(user.ID, "Cats at play", "")
+	dogs := create(user.ID, "Walking the dog", "a day with cats and dogs")
+	discount := create(user.ID, "100% off sale", "")
+	create(user.ID, "100 reasons", "")
+	snake := create(user.ID, "snake_case tips", "")
+	snakeLike := create(user.ID, "snakescase tips", "")
+	create(other.ID, "Other user's cats", "")
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uuid.UUID
+	}{
+		{"title before description", "CATS", []uuid.UUID{cats, dogs}},
+		{"description only", "a day", []uuid.UUID{dogs}},
+		{"no match", "hamster", []uuid.UUID{}},
+		{"percent is literal", "100%", []uuid.UUID{discount}},
+		{"underscore is literal", "snake_", []uuid.UUID{snake}},
+		{"plain text still matches", "snakes", []uuid.UUID{snakeLike}},
+		{"backslash is literal", `\`, []uuid.UUID{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videos, err := c.SearchVideos(user.ID, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []uuid.UUID{}
+			for _, video := range videos {
+				got = append(got, video.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SearchVideos(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceVideoThumbnailURL(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "Video", UserID: user.ID, Visibility: VisibilityPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldURL := "http://localhost:8091/assets/a.png"
+	video.ThumbnailURL = &oldURL
+	if video, err = c.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     uuid.UUID
+		oldURL string
+		want   bool
+	}{
+		{"stale URL", video.ID, "http://localhost:8091/assets/other.png", false},
+		{"unknown video", uuid.New(), oldURL, false},
+		{"current URL", video.ID, oldURL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replaced, err := c.ReplaceVideoThumbnailURL(tt.id, tt.oldURL, "https://cdn.example.com/a.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced != tt.want {
+				t.Errorf("replaced = %v, want %v", replaced, tt.want)
+			}
+		})
+	}
+
+	updated, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.ThumbnailURL != "https://cdn.example.com/a.png" {
+		t.Errorf("thumbnail URL = %s, want the replacement", *updated.ThumbnailURL)
+	}
+}
+
+func TestSetVideoMediaLeavesOtherColumns(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := c.CreateVideo(CreateVideoParams{Title: "Original", UserID: user.ID, Visibility: VisibilityPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := stale
+	thumbnailURL := "http://localhost:8091/assets/thumb.png"
+	current.Title = "Renamed"
+	current.Visibility = VisibilityPrivate
+	current.ThumbnailURL = &thumbnailURL
+	if _, err := c.UpdateVideo(current); err != nil {
+		t.Fatal(err)
+	}
+
+	videoURL := "http://localhost:8091/assets/landscape/a.mp4"
+	stale.VideoURL = &videoURL
+	stale.Width, stale.Height = 1920, 1080
+	stale.ProcessingStatus = ProcessingStatusReady
+	updated, err := c.SetVideoMedia(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Title != "Renamed" || updated.Visibility != VisibilityPrivate || updated.ThumbnailURL == nil {
+		t.Errorf("SetVideoMedia overwrote other columns: title %q, visibility %q, thumbnail %v", updated.Title, updated.Visibility, updated.ThumbnailURL)
+	}
+	if updated.VideoURL == nil || *updated.VideoURL != videoURL || updated.Width != 1920 || updated.ProcessingStatus != ProcessingStatusReady {
+		t.Errorf("media columns weren't written: %+v", updated)
+	}
+}
+
+func TestVideoTimestamps(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.CreateVideo(CreateVideoParams{Title: "Video", UserID: user.ID, Visibility: VisibilityPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since := time.Since(created.CreatedAt); since < -time.Second || since > time.Minute {
+		t.Errorf("created_at = %v, want about now", created.CreatedAt)
+	}
+	if !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Errorf("updated_at = %v, want created_at %v", created.UpdatedAt, created.CreatedAt)
+	}
+
+	previous := created
+	for i := 0; i < 2; i++ {
+		previous.Title = fmt.Sprintf("Video %d", i)
+		updated, err := c.UpdateVideo(previous)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("update %d changed created_at to %v", i+1, updated.CreatedAt)
+		}
+		if !updated.UpdatedAt.After(previous.UpdatedAt) {
+			t.Errorf("update %d: updated_at = %v, want after %v", i+1, updated.UpdatedAt, previous.UpdatedAt)
+		}
+		previous = updated
+	}
+}
+
+func TestValidVisibility(t *testing.T) {
+	tests := []struct {
+		visibility string
+		want       bool
+	}{
+		{VisibilityPublic, true},
+		{VisibilityUnlisted, true},
+		{VisibilityPrivate, true},
+		{"", false},
+		{"Unlisted", false},
+		{"hidden", false},
+	}
+	for _, tt := range tests {
+		if got := ValidVisibility(tt.visibility); got != tt.want {
+			t.Errorf("ValidVisibility(%q) = %v, want %v", tt.visibility, got, tt.want)
+		}
+	}
+}
+
+func TestVideoListingsAreStableOnTiedTimestamps(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 7; i++ {
+		video, err := c.CreateVideo(CreateVideoParams{Title: fmt.Sprintf("video %d", i), UserID: user.ID, Visibility: VisibilityPublic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, video.ID.String())
+	}
+	if _, err := c.db.Exec("UPDATE videos SET created_at = ?", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	// With every timestamp tied, the ID alone decides the order.
+	slices.Sort(ids)
+	slices.Reverse(ids)
+
+	for _, limit := range []int{1, 2, 3, 7} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			var got []string
+			for offset := 0; offset < len(ids); offset += limit {
+				page, err := c.GetVideosByUser(user.ID, limit, offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, video := range page {
+					got = append(got, video.ID.String())
+				}
+			}
+			if !slices.Equal(got, ids) {
+				t.Errorf("paged IDs = %v, want %v", got, ids)
+			}
+		})
+	}
+
+	public, err := c.GetPublicVideos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, video := range public {
+		got = append(got, video.ID.String())
+	}
+	if !slices.Equal(got, ids) {
+		t.Errorf("public IDs = %v, want %v", got, ids)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	c, _ := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "user@example.com", Password: "hashed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"revoked", "active"} {
+		if _, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: token, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.RevokeRefreshToken("revoked"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE token = 'revoked'", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking again must not move the original timestamp.
+	if err := c.RevokeRefreshToken("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token       string
+		wantRevoked bool
+	}{
+		{"revoked", true},
+		{"active", false},
+	}
+	for _, tt := range tests {
+		rt, err := c.GetRefreshToken(tt.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (rt.RevokedAt != nil) != tt.wantRevoked {
+			t.Errorf("%s: revoked_at = %v, want revoked %v", tt.token, rt.RevokedAt, tt.wantRevoked)
+		}
+	}
+	again, err := c.GetRefreshToken("revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.RevokedAt == nil || !again.RevokedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("revoked_at = %v after a second revoke, want it unchanged", again.RevokedAt)
+	}
+	if missing, err := c.GetRefreshToken("missing"); err != nil || missing.Token != "" {
+		t.Errorf("GetRefreshToken(missing) = %+v, %v; want a zero token", missing, err)
+	}
+}
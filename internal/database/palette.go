@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+type Palette []string
+
+func (p Palette) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (p *Palette) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("cannot scan %T into Palette", src)
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +15,10 @@ type RefreshToken struct {
 	RevokedAt *time.Time `json:"revoked_at"`
 }
 
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid, expired or revoked")
+
+// CreateRefreshTokenParams.Token is the hash of the issued token, see
+// auth.HashRefreshToken.
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
@@ -27,8 +32,9 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			hashed
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 1)
 	`
 	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
 	if err != nil {
@@ -38,11 +44,73 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes the token and stores its replacement in one
+// transaction, returning the owner. Only one concurrent rotation of the same
+// token can succeed.
+func (c Client) RotateRefreshToken(token string, next CreateRefreshTokenParams) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var expiresAt time.Time
+	var revokedAt *time.Time
+	err = tx.QueryRow(`
+		SELECT user_id, expires_at, revoked_at
+		FROM refresh_tokens
+		WHERE token = ?
+	`, token).Scan(&userID, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if revokedAt != nil || !time.Now().Before(expiresAt) {
+		return uuid.Nil, ErrInvalidRefreshToken
+	}
+
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`, token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return uuid.Nil, err
+	} else if rows != 1 {
+		return uuid.Nil, ErrInvalidRefreshToken
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			expires_at,
+			hashed
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 1)
+	`, next.Token, userID, next.ExpiresAt)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(userID)
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
-		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
 	`
 	_, err := c.db.Exec(query, token)
 	return err
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+type Renditions map[string]string
+
+func (r Renditions) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (r *Renditions) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	case []byte:
+		return json.Unmarshal(v, r)
+	default:
+		return fmt.Errorf("cannot scan %T into Renditions", src)
+	}
+}
@@ -3,29 +3,81 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                    uuid.UUID  `json:"id"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	ThumbnailURL          *string    `json:"thumbnail_url"`
+	VideoURL              *string    `json:"video_url"`
+	ContactSheetURL       *string    `json:"contact_sheet_url"`
+	SHA256                *string    `json:"sha256"`
+	Width                 int        `json:"width"`
+	Height                int        `json:"height"`
+	Bitrate               int64      `json:"bitrate"`
+	PixFmt                *string    `json:"pix_fmt"`
+	ColorSpace            *string    `json:"color_space"`
+	ColorTransfer         *string    `json:"color_transfer"`
+	ColorPrimaries        *string    `json:"color_primaries"`
+	DominantColor         *string    `json:"dominant_color"`
+	Chapters              Chapters   `json:"chapters"`
+	Orientation           string     `json:"orientation"`
+	HasAudio              bool       `json:"has_audio"`
+	Palette               Palette    `json:"palette"`
+	SampleAspectRatio     *string    `json:"sample_aspect_ratio"`
+	DisplayAspectRatio    *string    `json:"display_aspect_ratio"`
+	OriginalSizeBytes     int64      `json:"original_size_bytes"`
+	Duration              float64    `json:"duration"`
+	RecordedAt            *time.Time `json:"recorded_at"`
+	FileSize              int64      `json:"file_size"`
+	RenditionsSize        int64      `json:"renditions_size"`
+	ContactSheetSize      int64      `json:"contact_sheet_size"`
+	ThumbnailIsAuto       bool       `json:"thumbnail_is_auto"`
+	Renditions            Renditions `json:"renditions"`
+	CodecProfile          *string    `json:"codec_profile"`
+	CodecLevel            *string    `json:"codec_level"`
+	HasBFrames            int        `json:"has_b_frames"`
+	ProcessingStatus      string     `json:"processing_status"`
+	PreviousThumbnailURL  *string    `json:"previous_thumbnail_url"`
+	ThumbnailSize         int64      `json:"thumbnail_size"`
+	PreviousThumbnailSize int64      `json:"previous_thumbnail_size"`
+	ProcessingLog         *string    `json:"-"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+const (
+	ProcessingStatusProcessing = "processing"
+	ProcessingStatusReady      = "ready"
+	ProcessingStatusFailed     = "failed"
+)
+
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +85,201 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
+		contact_sheet_url,
+		sha256,
+		width,
+		height,
+		bitrate,
+		pix_fmt,
+		color_space,
+		color_transfer,
+		color_primaries,
+		visibility,
+		dominant_color,
+		chapters,
+		processing_log,
+		orientation,
+		has_audio,
+		palette,
+		sample_aspect_ratio,
+		display_aspect_ratio,
+		original_size_bytes,
+		duration,
+		recorded_at,
+		file_size,
+		renditions_size,
+		contact_sheet_size,
+		thumbnail_is_auto,
+		renditions,
+		codec_profile,
+		codec_level,
+		has_b_frames,
+		processing_status,
+		previous_thumbnail_url,
+		thumbnail_size,
+		previous_thumbnail_size,
 		user_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.ContactSheetURL,
+		&video.SHA256,
+		&video.Width,
+		&video.Height,
+		&video.Bitrate,
+		&video.PixFmt,
+		&video.ColorSpace,
+		&video.ColorTransfer,
+		&video.ColorPrimaries,
+		&video.Visibility,
+		&video.DominantColor,
+		&video.Chapters,
+		&video.ProcessingLog,
+		&video.Orientation,
+		&video.HasAudio,
+		&video.Palette,
+		&video.SampleAspectRatio,
+		&video.DisplayAspectRatio,
+		&video.OriginalSizeBytes,
+		&video.Duration,
+		&video.RecordedAt,
+		&video.FileSize,
+		&video.RenditionsSize,
+		&video.ContactSheetSize,
+		&video.ThumbnailIsAuto,
+		&video.Renditions,
+		&video.CodecProfile,
+		&video.CodecLevel,
+		&video.HasBFrames,
+		&video.ProcessingStatus,
+		&video.PreviousThumbnailURL,
+		&video.ThumbnailSize,
+		&video.PreviousThumbnailSize,
+		&video.UserID,
+	)
+	return video, err
+}
+
+func (c Client) GetVideosByUser(userID uuid.UUID, limit, offset int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
-	ORDER BY created_at DESC
+	ORDER BY created_at DESC, id DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := c.db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
+
+// SearchVideos matches query case-insensitively against title and
+// description. Title matches rank above description-only matches.
+func (c Client) SearchVideos(userID uuid.UUID, query string) ([]Video, error) {
+	pattern := "%" + escapeLike(query) + "%"
+	sqlQuery := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+	AND (title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')
+	ORDER BY (title LIKE ? ESCAPE '\') DESC, created_at DESC, id DESC
+	`
+
+	rows, err := c.db.Query(sqlQuery, userID, pattern, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (c Client) CountVideosByUser(userID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+func (c Client) GetPublicVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE visibility = ?
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := c.db.Query(query, VisibilityPublic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
+
+func (c Client) GetVideosWithThumbnailPrefix(prefix string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE substr(thumbnail_url, 1, length(?)) = ?
+	ORDER BY created_at ASC, id ASC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, prefix, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +287,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -75,10 +306,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		visibility,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +320,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,30 +336,221 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+func (c Client) UpdateVideo(video Video) (Video, error) {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
+		contact_sheet_url = ?,
+		sha256 = ?,
+		width = ?,
+		height = ?,
+		bitrate = ?,
+		pix_fmt = ?,
+		color_space = ?,
+		color_transfer = ?,
+		color_primaries = ?,
+		visibility = ?,
+		dominant_color = ?,
+		chapters = ?,
+		processing_log = ?,
+		orientation = ?,
+		has_audio = ?,
+		palette = ?,
+		sample_aspect_ratio = ?,
+		display_aspect_ratio = ?,
+		original_size_bytes = ?,
+		duration = ?,
+		recorded_at = ?,
+		file_size = ?,
+		renditions_size = ?,
+		contact_sheet_size = ?,
+		thumbnail_is_auto = ?,
+		renditions = ?,
+		codec_profile = ?,
+		codec_level = ?,
+		has_b_frames = ?,
+		processing_status = ?,
+		previous_thumbnail_url = ?,
+		thumbnail_size = ?,
+		previous_thumbnail_size = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
 	_, err := c.db.Exec(
 		query,
+		time.Now().UTC(),
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		&video.ContactSheetURL,
+		&video.SHA256,
+		video.Width,
+		video.Height,
+		video.Bitrate,
+		video.PixFmt,
+		video.ColorSpace,
+		video.ColorTransfer,
+		video.ColorPrimaries,
+		video.Visibility,
+		video.DominantColor,
+		video.Chapters,
+		video.ProcessingLog,
+		video.Orientation,
+		video.HasAudio,
+		video.Palette,
+		video.SampleAspectRatio,
+		video.DisplayAspectRatio,
+		video.OriginalSizeBytes,
+		video.Duration,
+		video.RecordedAt,
+		video.FileSize,
+		video.RenditionsSize,
+		video.ContactSheetSize,
+		video.ThumbnailIsAuto,
+		video.Renditions,
+		video.CodecProfile,
+		video.CodecLevel,
+		video.HasBFrames,
+		video.ProcessingStatus,
+		video.PreviousThumbnailURL,
+		video.ThumbnailSize,
+		video.PreviousThumbnailSize,
 		video.UserID,
 		video.ID,
 	)
+	if err != nil {
+		return Video{}, err
+	}
+
+	return c.GetVideo(video.ID)
+}
+
+// SetVideoMedia writes the columns an upload derives from the video file,
+// leaving the title, visibility and thumbnail as they are in the row.
+func (c Client) SetVideoMedia(video Video) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
+		video_url = ?,
+		contact_sheet_url = ?,
+		sha256 = ?,
+		width = ?,
+		height = ?,
+		bitrate = ?,
+		pix_fmt = ?,
+		color_space = ?,
+		color_transfer = ?,
+		color_primaries = ?,
+		chapters = ?,
+		processing_log = ?,
+		orientation = ?,
+		has_audio = ?,
+		sample_aspect_ratio = ?,
+		display_aspect_ratio = ?,
+		original_size_bytes = ?,
+		duration = ?,
+		recorded_at = ?,
+		file_size = ?,
+		renditions_size = ?,
+		contact_sheet_size = ?,
+		renditions = ?,
+		codec_profile = ?,
+		codec_level = ?,
+		has_b_frames = ?,
+		processing_status = ?
+	WHERE id = ?
+	`
+
+	_, err := c.db.Exec(
+		query,
+		time.Now().UTC(),
+		&video.VideoURL,
+		&video.ContactSheetURL,
+		&video.SHA256,
+		video.Width,
+		video.Height,
+		video.Bitrate,
+		video.PixFmt,
+		video.ColorSpace,
+		video.ColorTransfer,
+		video.ColorPrimaries,
+		video.Chapters,
+		video.ProcessingLog,
+		video.Orientation,
+		video.HasAudio,
+		video.SampleAspectRatio,
+		video.DisplayAspectRatio,
+		video.OriginalSizeBytes,
+		video.Duration,
+		video.RecordedAt,
+		video.FileSize,
+		video.RenditionsSize,
+		video.ContactSheetSize,
+		video.Renditions,
+		video.CodecProfile,
+		video.CodecLevel,
+		video.HasBFrames,
+		video.ProcessingStatus,
+		video.ID,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+
+	return c.GetVideo(video.ID)
+}
+
+// SetVideoProcessingStatus updates only the processing status.
+func (c Client) SetVideoProcessingStatus(id uuid.UUID, status string) error {
+	query := `
+	UPDATE videos
+	SET updated_at = ?, processing_status = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), status, id)
+	return err
+}
+
+// SetVideoProcessingLog updates only the processing log, leaving the rest of
+// the row as it is.
+func (c Client) SetVideoProcessingLog(id uuid.UUID, processingLog *string) error {
+	query := `
+	UPDATE videos
+	SET updated_at = ?, processing_log = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), processingLog, id)
 	return err
 }
 
+// ReplaceVideoThumbnailURL points the video at newURL only if its thumbnail
+// is still oldURL, and reports whether it did. Other columns are left alone,
+// so a concurrent upload's changes aren't overwritten.
+func (c Client) ReplaceVideoThumbnailURL(id uuid.UUID, oldURL, newURL string) (bool, error) {
+	query := `
+	UPDATE videos
+	SET updated_at = ?, thumbnail_url = ?
+	WHERE id = ? AND thumbnail_url = ?
+	`
+	result, err := c.db.Exec(query, time.Now().UTC(), newURL, id, oldURL)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
 	query := `
 	DELETE FROM videos
@@ -153,3 +559,14 @@ func (c Client) DeleteVideo(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id)
 	return err
 }
+
+func (c Client) GetUserStorageUsed(userID uuid.UUID) (int64, error) {
+	query := `
+	SELECT COALESCE(SUM(file_size + renditions_size + contact_sheet_size + thumbnail_size + previous_thumbnail_size), 0)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var used int64
+	err := c.db.QueryRow(query, userID).Scan(&used)
+	return used, err
+}
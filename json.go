@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+const readOnlyRetryAfterSeconds = "60"
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	if err != nil {
 		log.Println(err)
@@ -13,6 +17,10 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	if code > 499 {
 		log.Printf("Responding with 5XX error: %s", msg)
 	}
+	if pw, ok := w.(*problemResponseWriter); ok {
+		respondWithProblem(pw, code, msg)
+		return
+	}
 	type errorResponse struct {
 		Error string `json:"error"`
 	}
@@ -21,6 +29,55 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+type problemResponseWriter struct {
+	http.ResponseWriter
+	instance string
+}
+
+func (pw *problemResponseWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+func problemDetailsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemResponseWriter{ResponseWriter: w, instance: r.URL.Path}, r)
+	})
+}
+
+func respondWithProblem(pw *problemResponseWriter, code int, msg string) {
+	type problemDetails struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+	}
+	dat, err := json.Marshal(problemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   msg,
+		Instance: pw.instance,
+	})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		pw.WriteHeader(500)
+		return
+	}
+	pw.Header().Set("Content-Type", "application/problem+json")
+	pw.WriteHeader(code)
+	pw.Write(dat)
+}
+
+func respondWithWriteError(w http.ResponseWriter, msg string, err error) {
+	if database.IsReadOnlyError(err) {
+		w.Header().Set("Retry-After", readOnlyRetryAfterSeconds)
+		respondWithError(w, http.StatusServiceUnavailable, "Database is read-only, try again later", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
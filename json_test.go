@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRespondWithErrorFormats(t *testing.T) {
+	tests := []struct {
+		name            string
+		problemDetails  bool
+		wantContentType string
+		wantBody        map[string]any
+	}{
+		{
+			name:            "default",
+			wantContentType: "application/json",
+			wantBody:        map[string]any{"error": "Invalid video ID"},
+		},
+		{
+			name:            "problem details",
+			problemDetails:  true,
+			wantContentType: "application/problem+json",
+			wantBody: map[string]any{
+				"type":     "about:blank",
+				"title":    "Bad Request",
+				"status":   float64(http.StatusBadRequest),
+				"detail":   "Invalid video ID",
+				"instance": "/api/videos/not-a-uuid",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			var handler http.Handler = http.HandlerFunc(cfg.handlerVideoGet)
+			if tt.problemDetails {
+				handler = problemDetailsMiddleware(handler)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/not-a-uuid", nil)
+			req.SetPathValue("videoID", "not-a-uuid")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(body, tt.wantBody) {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestProblemDetailsLeavesSuccessResponses(t *testing.T) {
+	handler := problemDetailsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/videos", nil))
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := w.Body.String(); got != `{"status":"ok"}` {
+		t.Errorf("body = %s, want the handler's JSON unchanged", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var keyTemplatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var (
+	videoKeyPlaceholders     = []string{"userID", "videoID", "rand", "ext", "aspect", "date"}
+	thumbnailKeyPlaceholders = []string{"userID", "videoID", "rand", "ext", "date"}
+)
+
+type keyTemplateValues struct {
+	UserID  uuid.UUID
+	VideoID uuid.UUID
+	Ext     string
+	Aspect  string
+}
+
+func renderKeyTemplate(template string, values keyTemplateValues) (string, error) {
+	id, err := randomAssetID()
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(
+		"{userID}", values.UserID.String(),
+		"{videoID}", values.VideoID.String(),
+		"{rand}", id,
+		"{ext}", values.Ext,
+		"{aspect}", values.Aspect,
+		"{date}", time.Now().UTC().Format("2006/01/02"),
+	).Replace(template), nil
+}
+
+func validateKeyTemplate(template string, allowed []string) error {
+	if template == "" {
+		return fmt.Errorf("template must not be empty")
+	}
+	for _, match := range keyTemplatePlaceholder.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(allowed, match[1]) {
+			return fmt.Errorf("unknown placeholder {%s} in template %q", match[1], template)
+		}
+	}
+	if !strings.Contains(template, "{rand}") {
+		return fmt.Errorf("template %q must contain {rand}", template)
+	}
+
+	sample, err := renderKeyTemplate(template, keyTemplateValues{
+		UserID:  uuid.New(),
+		VideoID: uuid.New(),
+		Ext:     ".mp4",
+		Aspect:  "landscape",
+	})
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(sample, "{}") {
+		return fmt.Errorf("template %q has unbalanced braces", template)
+	}
+	if err := validateKeyPrefix(sample); err != nil {
+		return fmt.Errorf("template %q does not render a safe key: %v", template, err)
+	}
+	return nil
+}
+
+func tenantSegment(userID uuid.UUID) string {
+	sum := sha256.Sum256([]byte(userID.String()))
+	return "t-" + hex.EncodeToString(sum[:8])
+}
+
+func (cfg apiConfig) userKey(userID uuid.UUID, key string) string {
+	if !cfg.tenantIsolation {
+		return key
+	}
+	return path.Join(tenantSegment(userID), key)
+}
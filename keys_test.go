@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRenderKeyTemplate(t *testing.T) {
+	original := randReader
+	randReader = bytes.NewReader(make([]byte, 32*10))
+	t.Cleanup(func() { randReader = original })
+	rand := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+
+	userID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	videoID := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	date := time.Now().UTC().Format("2006/01/02")
+
+	tests := []struct {
+		name     string
+		template string
+		values   keyTemplateValues
+		want     string
+	}{
+		{"default video", "{aspect}/{rand}{ext}", keyTemplateValues{Ext: ".mp4", Aspect: "landscape"}, "landscape/" + rand + ".mp4"},
+		{"default thumbnail", "{rand}{ext}", keyTemplateValues{Ext: ".png"}, rand + ".png"},
+		{"per user and video", "users/{userID}/{videoID}/{rand}{ext}", keyTemplateValues{UserID: userID, VideoID: videoID, Ext: ".mp4"}, "users/" + userID.String() + "/" + videoID.String() + "/" + rand + ".mp4"},
+		{"dated", "{date}/{rand}{ext}", keyTemplateValues{Ext: ".jpg"}, date + "/" + rand + ".jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderKeyTemplate(tt.template, tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderKeyTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		allowed  []string
+		wantErr  string
+	}{
+		{"default video", "{aspect}/{rand}{ext}", videoKeyPlaceholders, ""},
+		{"default thumbnail", "{rand}{ext}", thumbnailKeyPlaceholders, ""},
+		{"every video placeholder", "{userID}/{videoID}/{date}/{aspect}/{rand}{ext}", videoKeyPlaceholders, ""},
+		{"empty", "", videoKeyPlaceholders, "must not be empty"},
+		{"unknown placeholder", "{owner}/{rand}{ext}", videoKeyPlaceholders, "unknown placeholder {owner}"},
+		{"aspect on a thumbnail", "{aspect}/{rand}{ext}", thumbnailKeyPlaceholders, "unknown placeholder {aspect}"},
+		{"no rand", "{videoID}{ext}", videoKeyPlaceholders, "must contain {rand}"},
+		{"unbalanced braces", "{rand}{ext", videoKeyPlaceholders, "unbalanced braces"},
+		{"absolute path", "/{rand}{ext}", videoKeyPlaceholders, "safe key"},
+		{"parent directory", "../{rand}{ext}", videoKeyPlaceholders, "safe key"},
+		{"space", "my videos/{rand}{ext}", videoKeyPlaceholders, "safe key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyTemplate(tt.template, tt.allowed)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserKey(t *testing.T) {
+	alice := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	bob := uuid.MustParse("33333333-3333-3333-3333-333333333333")
+	if tenantSegment(alice) != tenantSegment(alice) {
+		t.Error("tenant segment isn't deterministic")
+	}
+	if tenantSegment(alice) == tenantSegment(bob) {
+		t.Errorf("users share tenant segment %s", tenantSegment(alice))
+	}
+
+	tests := []struct {
+		name            string
+		tenantIsolation bool
+		userID          uuid.UUID
+		want            string
+	}{
+		{"isolation off", false, alice, "videos/a.mp4"},
+		{"alice", true, alice, tenantSegment(alice) + "/videos/a.mp4"},
+		{"bob", true, bob, tenantSegment(bob) + "/videos/a.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := apiConfig{tenantIsolation: tt.tenantIsolation}
+			if got := cfg.userKey(tt.userID, "videos/a.mp4"); got != tt.want {
+				t.Errorf("userKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type videoLocks struct {
+	mu     sync.Mutex
+	locked map[uuid.UUID]struct{}
+}
+
+func newVideoLocks() *videoLocks {
+	return &videoLocks{
+		locked: make(map[uuid.UUID]struct{}),
+	}
+}
+
+func (l *videoLocks) tryLock(videoID uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.locked[videoID]; ok {
+		return false
+	}
+	l.locked[videoID] = struct{}{}
+	return true
+}
+
+func (l *videoLocks) unlock(videoID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.locked, videoID)
+}
+
+type videoCooldowns struct {
+	mu       sync.Mutex
+	uploaded map[uuid.UUID]time.Time
+}
+
+func newVideoCooldowns() *videoCooldowns {
+	return &videoCooldowns{
+		uploaded: make(map[uuid.UUID]time.Time),
+	}
+}
+
+// remaining reports how much of the cool-down window is left for a video.
+func (c *videoCooldowns) remaining(videoID uuid.UUID, window time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	uploadedAt, ok := c.uploaded[videoID]
+	if !ok {
+		return 0
+	}
+	left := window - time.Since(uploadedAt)
+	if left <= 0 {
+		delete(c.uploaded, videoID)
+		return 0
+	}
+	return left
+}
+
+func (c *videoCooldowns) mark(videoID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploaded[videoID] = time.Now()
+}
+
+// prune drops videos whose cool-down window has passed. remaining only
+// clears the videos it's asked about, so ones never re-uploaded would stay.
+func (c *videoCooldowns) prune(now time.Time, window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for videoID, uploadedAt := range c.uploaded {
+		if now.Sub(uploadedAt) >= window {
+			delete(c.uploaded, videoID)
+		}
+	}
+}
+
+func (cfg *apiConfig) runCooldownPruning(ctx context.Context) {
+	ticker := time.NewTicker(cfg.uploadCooldown)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cfg.uploadCooldowns.prune(now, cfg.uploadCooldown)
+		}
+	}
+}
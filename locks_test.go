@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func deleteVideo(cfg *apiConfig, videoID uuid.UUID, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+videoID.String(), nil)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.handlerVideoMetaDelete(w, req)
+	return w
+}
+
+func TestWritesConflictWhileProcessing(t *testing.T) {
+	installFakeFFmpeg(t)
+
+	tests := []struct {
+		name     string
+		request  func(cfg *apiConfig, videoID uuid.UUID, token string) *httptest.ResponseRecorder
+		unlocked int
+	}{
+		{"video upload", func(cfg *apiConfig, videoID uuid.UUID, token string) *httptest.ResponseRecorder {
+			return uploadVideo(t, cfg, videoID, token, "", testMP4(1000))
+		}, http.StatusOK},
+		{"thumbnail upload", func(cfg *apiConfig, videoID uuid.UUID, token string) *httptest.ResponseRecorder {
+			return uploadThumbnail(t, cfg, videoID, token, testPNG(t, 64, 64))
+		}, http.StatusOK},
+		{"delete", deleteVideo, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if !cfg.processingLocks.tryLock(video.ID) {
+				t.Fatal("couldn't take the processing lock")
+			}
+			if w := tt.request(cfg, video.ID, token); w.Code != http.StatusConflict {
+				t.Fatalf("expected 409 while processing, got %d: %s", w.Code, w.Body)
+			}
+			if _, err := cfg.db.GetVideo(video.ID); err != nil {
+				t.Fatalf("video changed while locked: %v", err)
+			}
+
+			cfg.processingLocks.unlock(video.ID)
+			if w := tt.request(cfg, video.ID, token); w.Code != tt.unlocked {
+				t.Fatalf("expected %d once processing finished, got %d: %s", tt.unlocked, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestRotateAssetsSkipsLockedVideos(t *testing.T) {
+	fake := &fakeS3{existing: map[string]bool{}}
+	cfg := newFakeS3Config(t, fake)
+	cfg.assetRotationMaxAge = time.Hour
+	userID, _ := createTestUser(t, cfg, "user@example.com")
+	video := createLocalThumbnail(t, cfg, createTestVideo(t, cfg, userID, database.VisibilityPublic), "old.png", 2*time.Hour)
+
+	cfg.processingLocks.tryLock(video.ID)
+	if err := cfg.rotateAssets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.puts) != 0 {
+		t.Errorf("locked video was rotated: %v", fake.puts)
+	}
+
+	cfg.processingLocks.unlock(video.ID)
+	if err := cfg.rotateAssets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.puts) != 1 {
+		t.Errorf("unlocked video wasn't rotated: %v", fake.puts)
+	}
+}
+
+func TestVideoCooldownsPrune(t *testing.T) {
+	cooldowns := newVideoCooldowns()
+	expired, cooling := uuid.New(), uuid.New()
+	cooldowns.mark(expired)
+	cooldowns.mark(cooling)
+	cooldowns.uploaded[expired] = cooldowns.uploaded[expired].Add(-time.Minute)
+
+	cooldowns.prune(time.Now(), 30*time.Second)
+
+	if _, ok := cooldowns.uploaded[expired]; ok {
+		t.Error("expired cool-down wasn't pruned")
+	}
+	if _, ok := cooldowns.uploaded[cooling]; !ok {
+		t.Error("active cool-down was pruned")
+	}
+}
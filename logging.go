@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+func redactSensitive(headers http.Header) http.Header {
+	clean := headers.Clone()
+	for _, name := range sensitiveHeaders {
+		values := clean.Values(name)
+		for i, value := range values {
+			scheme, _, found := strings.Cut(value, " ")
+			if found && name == "Authorization" {
+				values[i] = scheme + " " + redacted
+			} else {
+				values[i] = redacted
+			}
+		}
+	}
+	for _, values := range clean {
+		for i, value := range values {
+			values[i] = jwtPattern.ReplaceAllString(value, redacted)
+		}
+	}
+	return clean
+}
+
+func logRequestsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s headers=%v", r.Method, r.URL.Path, redactSensitive(r.Header))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"image/jpeg"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 
 	"github.com/joho/godotenv"
@@ -20,11 +26,95 @@ type apiConfig struct {
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
+	quarantineDir    string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	s3Endpoint       string
+	s3UsePathStyle   bool
+	forceHTTPSURLs   bool
 	port             string
 	s3Client         *s3.Client
+	devMode          bool
+
+	s3PartSize          int64
+	s3UploadConcurrency int
+
+	confirmUploads bool
+	requesterPays  bool
+	s3KMSKeyID     string
+	adminAPIKey    string
+
+	trustBucketHeader bool
+	bucketAllowlist   []string
+
+	presignVideoURLs bool
+	presignExpiry    time.Duration
+
+	s3ObjectLockMode      string
+	s3ObjectLockRetention time.Duration
+
+	maxVideoUploadBytes     int64
+	maxThumbnailUploadBytes int64
+	maxUserStorageBytes     int64
+	maxVideoDurationSeconds int
+	maxStreamCount          int
+	minVideoWidth           int
+	minVideoHeight          int
+	strictExtensionCheck    bool
+	uploadTimeout           time.Duration
+	uploadCooldown          time.Duration
+	uploadsPerMinute        int
+	multipartThreshold      int64
+
+	landscapePrefix        string
+	portraitPrefix         string
+	standardPrefix         string
+	standardPortraitPrefix string
+	squarePrefix           string
+	ultrawidePrefix        string
+	otherPrefix            string
+
+	videoKeyTemplate     string
+	thumbnailKeyTemplate string
+	tenantIsolation      bool
+
+	thumbnailAspectMode          string
+	thumbnailJPEGQuality         int
+	maxThumbnailDimension        int
+	maxImageSourceDimension      int
+	maxImageSourcePixels         int64
+	autoThumbnailFormat          string
+	regenerateAutoThumbnails     bool
+	transcodeWebP                bool
+	thumbnailReplaceMode         string
+	deleteThumbnailOnVideoDelete bool
+
+	ffmpegMaxAttempts     int
+	retryBudgetAttempts   int
+	retryBudgetDuration   time.Duration
+	ffmpegRetryableErrors []string
+
+	assetRotationInterval time.Duration
+	assetRotationMaxAge   time.Duration
+	assetRotationMaxBytes int64
+
+	contactSheetFrames  int
+	contactSheetColumns int
+	contactSheetRows    int
+
+	logRequests    bool
+	problemDetails bool
+
+	metadataCacheMaxAge int
+
+	requireUserAgent   bool
+	userAgentBlocklist []string
+
+	processingLocks   *videoLocks
+	uploadCooldowns   *videoCooldowns
+	uploadRateLimiter *userRateLimiter
+	uploadProgress    *uploadProgressTracker
 }
 
 func main() {
@@ -60,31 +150,391 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	quarantineDir := os.Getenv("QUARANTINE_DIR")
+
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
-	}
 
 	s3Region := os.Getenv("S3_REGION")
 	if s3Region == "" {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
+	// S3-compatible stores (MinIO, LocalStack) can serve objects directly
+	// from their endpoint instead of through CloudFront.
+	s3Endpoint := strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/")
+	s3UsePathStyle, err := envBool("S3_USE_PATH_STYLE", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s3CfDistribution := strings.TrimSuffix(os.Getenv("S3_CF_DISTRO"), "/")
+	if s3CfDistribution == "" && s3Endpoint == "" {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	forceHTTPSURLs, err := envBool("FORCE_HTTPS_URLS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if forceHTTPSURLs && strings.HasPrefix(s3CfDistribution, "http://") {
+		log.Fatal("S3_CF_DISTRO must use https when FORCE_HTTPS_URLS is set")
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Config, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	maxVideoUploadBytes, err := envInt64("MAX_VIDEO_UPLOAD_BYTES", 1<<30)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxThumbnailUploadBytes, err := envInt64("MAX_THUMBNAIL_UPLOAD_BYTES", 10<<20)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxUserStorageBytes, err := envInt64("MAX_USER_STORAGE_BYTES", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxVideoDurationSeconds, err := envInt("MAX_VIDEO_DURATION_SECONDS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maxStreamCount, err := envInt("MAX_STREAM_COUNT", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	minVideoWidth, err := envInt("MIN_VIDEO_WIDTH", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	minVideoHeight, err := envInt("MIN_VIDEO_HEIGHT", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	strictExtensionCheck, err := envBool("STRICT_EXTENSION_CHECK", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	uploadTimeoutSeconds, err := envInt("UPLOAD_TIMEOUT_SECONDS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uploadTimeoutSeconds < 0 {
+		log.Fatal("UPLOAD_TIMEOUT_SECONDS must not be negative")
+	}
+
+	uploadsPerMinute, err := envInt("UPLOADS_PER_MINUTE", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	uploadCooldownSeconds, err := envInt("UPLOAD_COOLDOWN_SECONDS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uploadCooldownSeconds < 0 {
+		log.Fatal("UPLOAD_COOLDOWN_SECONDS must not be negative")
+	}
+
+	landscapePrefix := envString("VIDEO_PREFIX_LANDSCAPE", "landscape")
+	portraitPrefix := envString("VIDEO_PREFIX_PORTRAIT", "portrait")
+	standardPrefix := envString("VIDEO_PREFIX_STANDARD", "standard")
+	standardPortraitPrefix := envString("VIDEO_PREFIX_STANDARD_PORTRAIT", "standard-portrait")
+	squarePrefix := envString("VIDEO_PREFIX_SQUARE", "square")
+	ultrawidePrefix := envString("VIDEO_PREFIX_ULTRAWIDE", "ultrawide")
+	otherPrefix := envString("VIDEO_PREFIX_OTHER", "other")
+	for name, prefix := range map[string]string{
+		"VIDEO_PREFIX_LANDSCAPE":         landscapePrefix,
+		"VIDEO_PREFIX_PORTRAIT":          portraitPrefix,
+		"VIDEO_PREFIX_STANDARD":          standardPrefix,
+		"VIDEO_PREFIX_STANDARD_PORTRAIT": standardPortraitPrefix,
+		"VIDEO_PREFIX_SQUARE":            squarePrefix,
+		"VIDEO_PREFIX_ULTRAWIDE":         ultrawidePrefix,
+		"VIDEO_PREFIX_OTHER":             otherPrefix,
+	} {
+		if err := validateKeyPrefix(prefix); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// Uploads over the threshold are read straight off the body instead of
+	// through ParseMultipartForm, and sent to S3 in parts. They're still
+	// spooled to a temp file and processed like any other upload.
+	multipartThreshold, err := envInt64("MULTIPART_THRESHOLD_BYTES", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if multipartThreshold < 0 {
+		log.Fatal("MULTIPART_THRESHOLD_BYTES must not be negative")
+	}
+	if multipartThreshold >= maxVideoUploadBytes {
+		log.Fatal("MULTIPART_THRESHOLD_BYTES must be below MAX_VIDEO_UPLOAD_BYTES, or no upload would reach it")
+	}
+
+	videoKeyTemplate := envString("VIDEO_KEY_TEMPLATE", "{aspect}/{rand}{ext}")
+	if err := validateKeyTemplate(videoKeyTemplate, videoKeyPlaceholders); err != nil {
+		log.Fatalf("VIDEO_KEY_TEMPLATE: %v", err)
+	}
+	thumbnailKeyTemplate := envString("THUMBNAIL_KEY_TEMPLATE", "{rand}{ext}")
+	if err := validateKeyTemplate(thumbnailKeyTemplate, thumbnailKeyPlaceholders); err != nil {
+		log.Fatalf("THUMBNAIL_KEY_TEMPLATE: %v", err)
+	}
+
+	tenantIsolation, err := envBool("TENANT_ISOLATION", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	thumbnailAspectMode := os.Getenv("THUMBNAIL_ASPECT_MODE")
+	if thumbnailAspectMode != "" && thumbnailAspectMode != thumbnailAspectCrop && thumbnailAspectMode != thumbnailAspectPad {
+		log.Fatalf("THUMBNAIL_ASPECT_MODE must be empty, %q or %q", thumbnailAspectCrop, thumbnailAspectPad)
+	}
+
+	thumbnailJPEGQuality, err := envInt("THUMBNAIL_JPEG_QUALITY", jpeg.DefaultQuality)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := validateJPEGQuality(thumbnailJPEGQuality); err != nil {
+		log.Fatalf("THUMBNAIL_JPEG_QUALITY: %v", err)
+	}
+
+	maxThumbnailDimension, err := envInt("MAX_THUMBNAIL_DIMENSION", 1280)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if maxThumbnailDimension < 0 {
+		log.Fatal("MAX_THUMBNAIL_DIMENSION must not be negative")
+	}
+
+	maxImageSourceDimension, err := envInt("MAX_IMAGE_SOURCE_DIMENSION", 10000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if maxImageSourceDimension < 0 {
+		log.Fatal("MAX_IMAGE_SOURCE_DIMENSION must not be negative")
+	}
+
+	maxImageSourcePixels, err := envInt64("MAX_IMAGE_SOURCE_PIXELS", 40_000_000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if maxImageSourcePixels < 0 {
+		log.Fatal("MAX_IMAGE_SOURCE_PIXELS must not be negative")
+	}
+
+	autoThumbnailFormat := envString("AUTO_THUMBNAIL_FORMAT", autoThumbnailJPEG)
+	if autoThumbnailFormat != autoThumbnailJPEG && autoThumbnailFormat != autoThumbnailWebP {
+		log.Fatalf("AUTO_THUMBNAIL_FORMAT must be %q or %q", autoThumbnailJPEG, autoThumbnailWebP)
+	}
+
+	regenerateAutoThumbnails, err := envBool("REGENERATE_AUTO_THUMBNAILS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	transcodeWebP, err := envBool("TRANSCODE_WEBP", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deleteThumbnailOnVideoDelete, err := envBool("DELETE_THUMBNAIL_ON_VIDEO_DELETE", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	thumbnailReplaceMode := envString("THUMBNAIL_REPLACE_MODE", thumbnailReplaceOverwrite)
+	switch thumbnailReplaceMode {
+	case thumbnailReplaceOverwrite, thumbnailReplaceReject, thumbnailReplaceKeep:
+	default:
+		log.Fatalf("THUMBNAIL_REPLACE_MODE must be %q, %q or %q", thumbnailReplaceOverwrite, thumbnailReplaceReject, thumbnailReplaceKeep)
+	}
+
+	ffmpegMaxAttempts, err := envInt("FFMPEG_MAX_ATTEMPTS", 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ffmpegMaxAttempts < 1 {
+		log.Fatal("FFMPEG_MAX_ATTEMPTS must be at least 1")
+	}
+
+	retryBudgetAttempts, err := envInt("RETRY_BUDGET_ATTEMPTS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	retryBudgetSeconds, err := envInt("RETRY_BUDGET_SECONDS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ffmpegRetryableErrors := envList("FFMPEG_RETRYABLE_ERRORS")
+
+	contactSheetFrames, err := envInt("CONTACT_SHEET_FRAMES", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	contactSheetLayout := envString("CONTACT_SHEET_LAYOUT", "3x3")
+	contactSheetColumns, contactSheetRows, err := parseTileLayout(contactSheetLayout)
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_LAYOUT: %v", err)
+	}
+	if contactSheetFrames < 0 || contactSheetFrames > contactSheetColumns*contactSheetRows {
+		log.Fatalf("CONTACT_SHEET_FRAMES must be between 0 and %d for a %s layout", contactSheetColumns*contactSheetRows, contactSheetLayout)
+	}
+
+	metadataCacheMaxAge, err := envInt("METADATA_CACHE_MAX_AGE_SECONDS", 60)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if metadataCacheMaxAge < 0 {
+		log.Fatal("METADATA_CACHE_MAX_AGE_SECONDS must not be negative")
+	}
+
+	logRequests, err := envBool("LOG_REQUESTS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errorFormat := envString("ERROR_FORMAT", "json")
+	if errorFormat != "json" && errorFormat != "problem" {
+		log.Fatal(`ERROR_FORMAT must be "json" or "problem"`)
+	}
+
+	requireUserAgent, err := envBool("REQUIRE_USER_AGENT", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	userAgentBlocklist := envList("USER_AGENT_BLOCKLIST")
+
+	s3PartSizeMB, err := envInt64("S3_PART_SIZE_MB", 16)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s3PartSizeMB < 5 || s3PartSizeMB > 5*1024 {
+		log.Fatal("S3_PART_SIZE_MB must be between 5 and 5120")
+	}
+	s3UploadConcurrency, err := envInt("S3_UPLOAD_CONCURRENCY", manager.DefaultUploadConcurrency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s3UploadConcurrency < 1 {
+		log.Fatal("S3_UPLOAD_CONCURRENCY must be at least 1")
+	}
+
+	confirmUploads, err := envBool("S3_CONFIRM_UPLOADS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	requesterPays, err := envBool("S3_REQUESTER_PAYS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s3KMSKeyID := os.Getenv("S3_KMS_KEY_ID")
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	trustBucketHeader, err := envBool("TRUST_BUCKET_HEADER", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bucketAllowlist := envList("S3_BUCKET_ALLOWLIST")
+	if trustBucketHeader && len(bucketAllowlist) == 0 {
+		log.Fatal("TRUST_BUCKET_HEADER requires S3_BUCKET_ALLOWLIST")
+	}
+
+	presignVideoURLs, err := envBool("PRESIGN_VIDEO_URLS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	presignExpiryMinutes, err := envInt("PRESIGN_EXPIRY_MINUTES", 15)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if presignExpiryMinutes < 1 || presignExpiryMinutes > 7*24*60 {
+		log.Fatal("PRESIGN_EXPIRY_MINUTES must be between 1 and 10080")
+	}
+
+	devMode, err := envBool("DEV_MODE", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s3Bucket == "" && !devMode {
+		log.Fatal("S3_BUCKET environment variable is not set")
+	}
+
+	assetRotationIntervalMinutes, err := envInt("ASSET_ROTATION_INTERVAL_MINUTES", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assetRotationMaxAgeHours, err := envInt("ASSET_ROTATION_MAX_AGE_HOURS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assetRotationMaxBytes, err := envInt64("ASSET_ROTATION_MAX_BYTES", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if assetRotationIntervalMinutes < 0 || assetRotationMaxAgeHours < 0 || assetRotationMaxBytes < 0 {
+		log.Fatal("ASSET_ROTATION_* settings must not be negative")
+	}
+	if assetRotationIntervalMinutes > 0 {
+		if devMode || s3Bucket == "" {
+			log.Fatal("ASSET_ROTATION_INTERVAL_MINUTES requires an S3 bucket outside dev mode")
+		}
+		if assetRotationMaxAgeHours == 0 && assetRotationMaxBytes == 0 {
+			log.Fatal("ASSET_ROTATION_INTERVAL_MINUTES requires ASSET_ROTATION_MAX_AGE_HOURS or ASSET_ROTATION_MAX_BYTES")
+		}
+	}
+
+	s3MaxIdleConns, err := envInt("S3_MAX_IDLE_CONNS", 100)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s3MaxConnsPerHost, err := envInt("S3_MAX_CONNS_PER_HOST", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s3MaxIdleConns < 0 || s3MaxConnsPerHost < 0 {
+		log.Fatal("S3_MAX_IDLE_CONNS and S3_MAX_CONNS_PER_HOST must not be negative")
+	}
+
+	s3Config, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(s3Region),
+		config.WithHTTPClient(newS3HTTPClient(s3MaxIdleConns, s3MaxConnsPerHost)),
+	)
 	if err != nil {
 		log.Fatal("Failed to load s3 Config")
 	}
-	s3Client := s3.NewFromConfig(s3Config)
+	s3Client := s3.NewFromConfig(s3Config, func(o *s3.Options) {
+		if s3Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Endpoint)
+		}
+		o.UsePathStyle = s3UsePathStyle
+	})
+
+	s3ObjectLockMode := os.Getenv("S3_OBJECT_LOCK_MODE")
+	s3ObjectLockRetentionDays, err := envInt("S3_OBJECT_LOCK_RETENTION_DAYS", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s3ObjectLockMode != "" && !devMode {
+		if s3ObjectLockMode != string(types.ObjectLockModeGovernance) && s3ObjectLockMode != string(types.ObjectLockModeCompliance) {
+			log.Fatalf("S3_OBJECT_LOCK_MODE must be %s or %s", types.ObjectLockModeGovernance, types.ObjectLockModeCompliance)
+		}
+		if s3ObjectLockRetentionDays < 1 {
+			log.Fatal("S3_OBJECT_LOCK_RETENTION_DAYS must be at least 1 when S3_OBJECT_LOCK_MODE is set")
+		}
+		err = validateObjectLock(context.TODO(), s3Client, s3Bucket)
+		if err != nil {
+			log.Fatalf("S3_OBJECT_LOCK_MODE: %v", err)
+		}
+	}
 
 	cfg := apiConfig{
 		db:               db,
@@ -92,11 +542,95 @@ func main() {
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
+		quarantineDir:    quarantineDir,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
+		s3Endpoint:       s3Endpoint,
+		s3UsePathStyle:   s3UsePathStyle,
+		forceHTTPSURLs:   forceHTTPSURLs,
 		port:             port,
 		s3Client:         s3Client,
+		devMode:          devMode,
+
+		s3PartSize:          s3PartSizeMB << 20,
+		s3UploadConcurrency: s3UploadConcurrency,
+
+		confirmUploads: confirmUploads,
+		requesterPays:  requesterPays,
+		s3KMSKeyID:     s3KMSKeyID,
+		adminAPIKey:    adminAPIKey,
+
+		trustBucketHeader: trustBucketHeader,
+		bucketAllowlist:   bucketAllowlist,
+
+		presignVideoURLs: presignVideoURLs,
+		presignExpiry:    time.Duration(presignExpiryMinutes) * time.Minute,
+
+		s3ObjectLockMode:      s3ObjectLockMode,
+		s3ObjectLockRetention: time.Duration(s3ObjectLockRetentionDays) * 24 * time.Hour,
+
+		maxVideoUploadBytes:     maxVideoUploadBytes,
+		maxThumbnailUploadBytes: maxThumbnailUploadBytes,
+		maxUserStorageBytes:     maxUserStorageBytes,
+		maxVideoDurationSeconds: maxVideoDurationSeconds,
+		maxStreamCount:          maxStreamCount,
+		minVideoWidth:           minVideoWidth,
+		minVideoHeight:          minVideoHeight,
+		strictExtensionCheck:    strictExtensionCheck,
+		uploadTimeout:           time.Duration(uploadTimeoutSeconds) * time.Second,
+		uploadCooldown:          time.Duration(uploadCooldownSeconds) * time.Second,
+		uploadsPerMinute:        uploadsPerMinute,
+		multipartThreshold:      multipartThreshold,
+
+		landscapePrefix:        landscapePrefix,
+		portraitPrefix:         portraitPrefix,
+		standardPrefix:         standardPrefix,
+		standardPortraitPrefix: standardPortraitPrefix,
+		squarePrefix:           squarePrefix,
+		ultrawidePrefix:        ultrawidePrefix,
+		otherPrefix:            otherPrefix,
+
+		videoKeyTemplate:     videoKeyTemplate,
+		thumbnailKeyTemplate: thumbnailKeyTemplate,
+		tenantIsolation:      tenantIsolation,
+
+		thumbnailAspectMode:          thumbnailAspectMode,
+		thumbnailJPEGQuality:         thumbnailJPEGQuality,
+		maxThumbnailDimension:        maxThumbnailDimension,
+		maxImageSourceDimension:      maxImageSourceDimension,
+		maxImageSourcePixels:         maxImageSourcePixels,
+		autoThumbnailFormat:          autoThumbnailFormat,
+		regenerateAutoThumbnails:     regenerateAutoThumbnails,
+		transcodeWebP:                transcodeWebP,
+		thumbnailReplaceMode:         thumbnailReplaceMode,
+		deleteThumbnailOnVideoDelete: deleteThumbnailOnVideoDelete,
+
+		ffmpegMaxAttempts:     ffmpegMaxAttempts,
+		retryBudgetAttempts:   retryBudgetAttempts,
+		retryBudgetDuration:   time.Duration(retryBudgetSeconds) * time.Second,
+		ffmpegRetryableErrors: ffmpegRetryableErrors,
+
+		assetRotationInterval: time.Duration(assetRotationIntervalMinutes) * time.Minute,
+		assetRotationMaxAge:   time.Duration(assetRotationMaxAgeHours) * time.Hour,
+		assetRotationMaxBytes: assetRotationMaxBytes,
+
+		contactSheetFrames:  contactSheetFrames,
+		contactSheetColumns: contactSheetColumns,
+		contactSheetRows:    contactSheetRows,
+
+		logRequests:    logRequests,
+		problemDetails: errorFormat == "problem",
+
+		metadataCacheMaxAge: metadataCacheMaxAge,
+
+		requireUserAgent:   requireUserAgent,
+		userAgentBlocklist: userAgentBlocklist,
+
+		processingLocks:   newVideoLocks(),
+		uploadCooldowns:   newVideoCooldowns(),
+		uploadRateLimiter: newUserRateLimiter(uploadsPerMinute),
+		uploadProgress:    newUploadProgressTracker(),
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -104,31 +638,65 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if cfg.quarantineDir != "" {
+		err = os.MkdirAll(cfg.quarantineDir, 0755)
+		if err != nil {
+			log.Fatalf("Couldn't create quarantine directory: %v", err)
+		}
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("GET /assets/{path...}", noCacheMiddleware(http.HandlerFunc(cfg.handlerServeAsset)))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("PUT /api/users/preferences", cfg.handlerUsersUpdatePreferences)
+
+	mux.HandleFunc("GET /api/upload-config", cfg.handlerUploadConfig)
+	mux.HandleFunc("POST /api/upload-policy", cfg.handlerUploadPolicy)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.userAgentFilterMiddleware(cfg.uploadRateLimitMiddleware(cfg.uploadTimeoutMiddleware(http.HandlerFunc(cfg.handlerUploadThumbnail)))))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.userAgentFilterMiddleware(cfg.uploadRateLimitMiddleware(cfg.uploadTimeoutMiddleware(http.HandlerFunc(cfg.handlerUploadVideo)))))
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/public", cfg.handlerVideosPublic)
+	mux.HandleFunc("POST /api/videos/batch", cfg.handlerVideosBatch)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/processing-log", cfg.handlerVideoProcessingLog)
+	mux.HandleFunc("GET /api/videos/{videoID}/upload-progress", cfg.handlerUploadProgress)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /admin/videos/{videoID}/rotate-key", cfg.handlerAdminRotateVideoKey)
+
+	if cfg.assetRotationInterval > 0 {
+		go cfg.runAssetRotation(context.Background())
+	}
+	if cfg.uploadsPerMinute > 0 {
+		go cfg.runRateLimiterPruning(context.Background())
+	}
+	if cfg.uploadCooldown > 0 {
+		go cfg.runCooldownPruning(context.Background())
+	}
+
+	var handler http.Handler = mux
+	if cfg.problemDetails {
+		handler = problemDetailsMiddleware(handler)
+	}
+	if cfg.logRequests {
+		handler = logRequestsMiddleware(handler)
+	}
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: handler,
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
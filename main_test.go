@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestConfig returns a dev-mode config backed by a fresh database and
+// assets directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+	return &apiConfig{
+		db:                      db,
+		jwtSecret:               testJWTSecret,
+		assetsRoot:              t.TempDir(),
+		port:                    "8091",
+		devMode:                 true,
+		maxVideoUploadBytes:     1 << 30,
+		maxThumbnailUploadBytes: 10 << 20,
+		videoKeyTemplate:        "{aspect}/{rand}{ext}",
+		thumbnailKeyTemplate:    "{rand}{ext}",
+		landscapePrefix:         "landscape",
+		portraitPrefix:          "portrait",
+		otherPrefix:             "other",
+		thumbnailJPEGQuality:    75,
+		thumbnailReplaceMode:    thumbnailReplaceOverwrite,
+		ffmpegMaxAttempts:       1,
+		processingLocks:         newVideoLocks(),
+		uploadCooldowns:         newVideoCooldowns(),
+		uploadRateLimiter:       newUserRateLimiter(0),
+		uploadProgress:          newUploadProgressTracker(),
+	}
+}
+
+// createTestUser creates a user and returns its ID and an access token.
+func createTestUser(t *testing.T, cfg *apiConfig, email string) (uuid.UUID, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "hashed"})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("couldn't make JWT: %v", err)
+	}
+	return user.ID, token
+}
+
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID, visibility string) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "Test video",
+		UserID:     userID,
+		Visibility: visibility,
+	})
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	return video
+}
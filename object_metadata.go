@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// S3 limits user-defined metadata to 2 KB, counting the bytes of every key
+// and value.
+const maxObjectMetadataBytes = 2 << 10
+
+const objectMetadataHeaderPrefix = "X-Amz-Meta-"
+
+const (
+	metadataOriginalFilename = "original-filename"
+	metadataUserID           = "user-id"
+)
+
+// objectMetadata collects the X-Amz-Meta-* headers the client sent and adds
+// the keys the server always sets, which can't be overridden.
+func objectMetadata(header http.Header, userID uuid.UUID, filename string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for name, values := range header {
+		if !strings.HasPrefix(name, objectMetadataHeaderPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, objectMetadataHeaderPrefix))
+		if !validMetadataKey(key) {
+			return nil, fmt.Errorf("invalid metadata key %q", key)
+		}
+		value := strings.Join(values, ",")
+		if !validMetadataValue(value) {
+			return nil, fmt.Errorf("metadata %q must be printable ASCII", key)
+		}
+		metadata[key] = value
+	}
+
+	metadata[metadataUserID] = userID.String()
+	if filename != "" {
+		// Non-ASCII names are RFC 2047 encoded, as S3 only stores ASCII.
+		metadata[metadataOriginalFilename] = mime.QEncoding.Encode("utf-8", filename)
+	}
+
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+	if size > maxObjectMetadataBytes {
+		return nil, fmt.Errorf("object metadata is %d bytes, the maximum is %d", size, maxObjectMetadataBytes)
+	}
+	return metadata, nil
+}
+
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func validMetadataValue(value string) bool {
+	for _, r := range value {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
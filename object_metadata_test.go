@@ -0,0 +1,72 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	userID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		filename string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "server keys only",
+			filename: "clip.mp4",
+			want:     map[string]string{"user-id": userID.String(), "original-filename": "clip.mp4"},
+		},
+		{
+			name:    "client keys are lowercased",
+			headers: map[string]string{"X-Amz-Meta-Camera-Model": "Pixel 8", "Content-Type": "video/mp4"},
+			want:    map[string]string{"user-id": userID.String(), "camera-model": "Pixel 8"},
+		},
+		{
+			name:    "server keys win",
+			headers: map[string]string{"X-Amz-Meta-User-Id": "someone-else"},
+			want:    map[string]string{"user-id": userID.String()},
+		},
+		{
+			name:     "non-ASCII filename is encoded",
+			filename: "café.mp4",
+			want:     map[string]string{"user-id": userID.String(), "original-filename": "=?utf-8?q?caf=C3=A9.mp4?="},
+		},
+		{
+			name:    "invalid key",
+			headers: map[string]string{"X-Amz-Meta-Bad.Key": "x"},
+			wantErr: true,
+		},
+		{
+			name:    "non-printable value",
+			headers: map[string]string{"X-Amz-Meta-Notes": "line\tbreak"},
+			wantErr: true,
+		},
+		{
+			name:    "too large",
+			headers: map[string]string{"X-Amz-Meta-Notes": strings.Repeat("x", maxObjectMetadataBytes)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, value := range tt.headers {
+				header.Set(name, value)
+			}
+			got, err := objectMetadata(header, userID, tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) cleanupTempFile(path string, videoID uuid.UUID, processingFailed bool) {
+	if processingFailed && cfg.quarantineDir != "" {
+		dst := filepath.Join(cfg.quarantineDir, fmt.Sprintf("%s-%s", videoID, filepath.Base(path)))
+		err := moveFile(path, dst)
+		if err == nil {
+			log.Printf("Kept failed upload file at %s", dst)
+			return
+		}
+		log.Printf("Couldn't quarantine %s: %v", path, err)
+	}
+	os.Remove(path)
+}
+
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
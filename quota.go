@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// exceedsStorageQuota reports whether storing incoming bytes would put the
+// user over the quota. replaced is the size of whatever the upload deletes
+// once it's stored, which no longer counts.
+func (cfg *apiConfig) exceedsStorageQuota(userID uuid.UUID, replaced, incoming int64) (bool, error) {
+	if cfg.maxUserStorageBytes <= 0 {
+		return false, nil
+	}
+	used, err := cfg.db.GetUserStorageUsed(userID)
+	if err != nil {
+		return false, err
+	}
+	return used-replaced+incoming > cfg.maxUserStorageBytes, nil
+}
+
+// mediaSize is what a video upload stores: the file, its renditions and its
+// contact sheet. A re-upload replaces all of them.
+func mediaSize(video database.Video) int64 {
+	return video.FileSize + video.RenditionsSize + video.ContactSheetSize
+}
+
+// filesSize adds up the sizes of files on disk. Empty paths, for outputs
+// that weren't rendered, count as nothing.
+func filesSize(paths ...string) (int64, error) {
+	var total int64
+	for _, filePath := range paths {
+		if filePath == "" {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
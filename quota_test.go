@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestExceedsStorageQuota(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, _ := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+	video.FileSize = 600
+	video.ThumbnailSize = 100
+	video.PreviousThumbnailSize = 50
+	if _, err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	cfg.maxUserStorageBytes = 1000
+
+	tests := []struct {
+		name     string
+		replaced int64
+		incoming int64
+		want     bool
+	}{
+		{"under", 0, 200, false},
+		{"at the limit", 0, 250, false},
+		{"over", 0, 251, true},
+		{"replacing frees space", 600, 800, false},
+		{"replacing still over", 600, 851, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.exceedsStorageQuota(userID, tt.replaced, tt.incoming)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("exceedsStorageQuota() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoUploadQuota(t *testing.T) {
+	installFakeFFmpeg(t)
+	data := testMP4(1000)
+	tests := []struct {
+		name  string
+		quota int64
+		want  int
+	}{
+		{"over quota", int64(len(data)) - 1, http.StatusRequestEntityTooLarge},
+		{"at the boundary", int64(len(data)), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.maxUserStorageBytes = tt.quota
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if w := uploadVideo(t, cfg, video.ID, token, "", data); w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestVideoReplaceDeletesOldObject(t *testing.T) {
+	installFakeFFmpeg(t)
+	cfg := newTestConfig(t)
+	data := testMP4(1000)
+	cfg.maxUserStorageBytes = int64(len(data))
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	if w := uploadVideo(t, cfg, video.ID, token, "", data); w.Code != http.StatusOK {
+		t.Fatalf("first upload: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	first, _ := cfg.db.GetVideo(video.ID)
+
+	if w := uploadVideo(t, cfg, video.ID, token, "", data); w.Code != http.StatusOK {
+		t.Fatalf("replacing upload: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if assetExists(cfg, first.VideoURL) {
+		t.Error("replaced video file is still stored")
+	}
+	used, err := cfg.db.GetUserStorageUsed(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != int64(len(data)) {
+		t.Errorf("storage used = %d, want %d", used, len(data))
+	}
+}
+
+func TestThumbnailUploadQuota(t *testing.T) {
+	data := testPNG(t, 8, 8)
+	tests := []struct {
+		name  string
+		quota int64
+		want  int
+	}{
+		{"over quota", int64(len(data)) - 1, http.StatusRequestEntityTooLarge},
+		{"at the boundary", int64(len(data)), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.maxUserStorageBytes = tt.quota
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			w := uploadThumbnail(t, cfg, video.ID, token, data)
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			updated, _ := cfg.db.GetVideo(video.ID)
+			info, err := os.Stat(cfg.getAssetDiskPath(strings.TrimPrefix(*updated.ThumbnailURL, cfg.getAssetURL(""))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.ThumbnailSize != info.Size() {
+				t.Errorf("thumbnail size = %d, want %d", updated.ThumbnailSize, info.Size())
+			}
+		})
+	}
+}
+
+func TestVideoUploadQuotaCountsDerivedFiles(t *testing.T) {
+	installFakeFFmpeg(t)
+	// The fake ffmpeg copies its input, so the 720p and 480p renditions and
+	// the contact sheet are each as large as the video.
+	data := testMP4(1000)
+	size := int64(len(data))
+	tests := []struct {
+		name             string
+		query            string
+		contactSheet     bool
+		quota            int64
+		want             int
+		renditionsSize   int64
+		contactSheetSize int64
+	}{
+		{"video only", "", false, size, http.StatusOK, 0, 0},
+		{"contact sheet over quota", "", true, 2*size - 1, http.StatusRequestEntityTooLarge, 0, 0},
+		{"contact sheet fits", "", true, 2 * size, http.StatusOK, 0, size},
+		{"renditions over quota", "?transcode=true", false, 3*size - 1, http.StatusRequestEntityTooLarge, 0, 0},
+		{"renditions fit", "?transcode=true", false, 3 * size, http.StatusOK, 2 * size, 0},
+		{"everything fits", "?transcode=true", true, 4 * size, http.StatusOK, 2 * size, size},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.maxUserStorageBytes = tt.quota
+			if tt.contactSheet {
+				cfg.contactSheetFrames = 4
+				cfg.contactSheetColumns, cfg.contactSheetRows = 2, 2
+			}
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			if w := uploadVideo(t, cfg, video.ID, token, tt.query, data); w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			updated, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.RenditionsSize != tt.renditionsSize || updated.ContactSheetSize != tt.contactSheetSize {
+				t.Errorf("recorded renditions %d and contact sheet %d bytes, want %d and %d", updated.RenditionsSize, updated.ContactSheetSize, tt.renditionsSize, tt.contactSheetSize)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			used, err := cfg.db.GetUserStorageUsed(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := size + tt.renditionsSize + tt.contactSheetSize; used != want {
+				t.Errorf("storage used = %d, want %d", used, want)
+			}
+		})
+	}
+}
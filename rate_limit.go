@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// A bucket refills completely within a minute, so one idle for longer is
+// the same as a new one and can be dropped.
+const rateLimiterIdleTimeout = time.Minute
+
+type userLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+type userRateLimiter struct {
+	mu       sync.Mutex
+	perMin   int
+	limiters map[uuid.UUID]*userLimiter
+}
+
+func newUserRateLimiter(perMinute int) *userRateLimiter {
+	return &userRateLimiter{
+		perMin:   perMinute,
+		limiters: make(map[uuid.UUID]*userLimiter),
+	}
+}
+
+// reserve takes a token for userID at now and returns how long the caller
+// would have to wait for it. Nothing is taken when the wait isn't zero.
+func (l *userRateLimiter) reserve(userID uuid.UUID, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.limiters[userID]
+	if !ok {
+		entry = &userLimiter{limiter: rate.NewLimiter(rate.Limit(float64(l.perMin)/60), l.perMin)}
+		l.limiters[userID] = entry
+	}
+	entry.lastUsed = now
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+func (l *userRateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for userID, entry := range l.limiters {
+		if now.Sub(entry.lastUsed) > rateLimiterIdleTimeout {
+			delete(l.limiters, userID)
+		}
+	}
+}
+
+func (cfg *apiConfig) runRateLimiterPruning(ctx context.Context) {
+	ticker := time.NewTicker(rateLimiterIdleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cfg.uploadRateLimiter.prune(now)
+		}
+	}
+}
+
+// uploadRateLimitMiddleware limits uploads per user. Requests without a
+// valid token are passed on so the handler can reject them as usual.
+func (cfg *apiConfig) uploadRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadsPerMinute <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if delay := cfg.uploadRateLimiter.reserve(userID, time.Now()); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many uploads, try again later", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
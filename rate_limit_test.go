@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUploadRateLimitMiddleware(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.uploadsPerMinute = 2
+	cfg.uploadRateLimiter = newUserRateLimiter(cfg.uploadsPerMinute)
+	_, token := createTestUser(t, cfg, "user@example.com")
+
+	handler := cfg.uploadRateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	wantCodes := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, want := range wantCodes {
+		req := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i+1, want, w.Code)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After = %q, want 30", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestUserRateLimiterRefills(t *testing.T) {
+	limiter := newUserRateLimiter(2)
+	userID := uuid.New()
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		at    time.Duration
+		limit bool
+	}{
+		{"first", 0, false},
+		{"second", 0, false},
+		{"over the limit", time.Second, true},
+		{"still empty", 29 * time.Second, true},
+		{"one token back", 31 * time.Second, false},
+		{"empty again", 32 * time.Second, true},
+		{"refilled", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		delay := limiter.reserve(userID, start.Add(tt.at))
+		if limited := delay > 0; limited != tt.limit {
+			t.Errorf("%s: limited = %v (delay %v), want %v", tt.name, limited, delay, tt.limit)
+		}
+	}
+}
+
+func TestUserRateLimiterPrune(t *testing.T) {
+	limiter := newUserRateLimiter(2)
+	idle, active := uuid.New(), uuid.New()
+	start := time.Now()
+
+	limiter.reserve(idle, start)
+	limiter.reserve(active, start.Add(time.Minute))
+	limiter.prune(start.Add(time.Minute + time.Second))
+
+	if _, ok := limiter.limiters[idle]; ok {
+		t.Error("idle limiter wasn't pruned")
+	}
+	if _, ok := limiter.limiters[active]; !ok {
+		t.Error("active limiter was pruned")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type rendition struct {
+	Quality string
+	Height  int
+}
+
+var videoRenditions = []rendition{
+	{"720p", 720},
+	{"480p", 480},
+}
+
+func (cfg *apiConfig) transcodeVideo(ctx context.Context, inputFilePath, scale string) (string, error) {
+	outputFilePath := fmt.Sprintf("%s.%s.mp4", inputFilePath, scale)
+	err := cfg.runFFmpegCommand(ctx, "transcoding video", nil, "ffmpeg",
+		"-y",
+		"-i", inputFilePath,
+		"-vf", "scale="+scale,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-c:a", "aac",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		outputFilePath,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	fileInfo, err := os.Stat(outputFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat transcoded file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return "", fmt.Errorf("transcoded file is empty")
+	}
+	return outputFilePath, nil
+}
+
+// transcodeRenditions returns the transcoded file for each rendition smaller
+// than the source. The caller removes the files.
+func (cfg *apiConfig) transcodeRenditions(ctx context.Context, inputFilePath string, sourceHeight int) (map[string]string, error) {
+	files := map[string]string{}
+	for _, rend := range videoRenditions {
+		if rend.Height >= sourceHeight {
+			continue
+		}
+		filePath, err := cfg.transcodeVideo(ctx, inputFilePath, fmt.Sprintf("-2:%d", rend.Height))
+		if err != nil {
+			removeRenditionFiles(files)
+			return nil, fmt.Errorf("%s: %w", rend.Quality, err)
+		}
+		files[rend.Quality] = filePath
+	}
+	return files, nil
+}
+
+func removeRenditionFiles(files map[string]string) {
+	for _, filePath := range files {
+		os.Remove(filePath)
+	}
+}
+
+// uploadRenditions stores each rendition under a {quality}/ prefix, using
+// newKey for the rest of the key. On failure, renditions already uploaded
+// are deleted again.
+func (cfg *apiConfig) uploadRenditions(ctx context.Context, bucket string, userID uuid.UUID, files map[string]string, newKey func() (string, error), metadata map[string]string) (database.Renditions, error) {
+	renditions := database.Renditions{}
+	uploadedKeys := []string{}
+	for quality, filePath := range files {
+		key, err := cfg.uploadRendition(ctx, bucket, userID, quality, filePath, newKey, metadata)
+		if err != nil {
+			for _, uploadedKey := range uploadedKeys {
+				if delErr := cfg.deleteObject(context.Background(), bucket, uploadedKey); delErr != nil {
+					log.Printf("Couldn't clean up rendition %s: %v", uploadedKey, delErr)
+				}
+			}
+			return nil, fmt.Errorf("%s: %w", quality, err)
+		}
+		uploadedKeys = append(uploadedKeys, key)
+		renditions[quality] = cfg.storedVideoURL(bucket, key)
+	}
+	return renditions, nil
+}
+
+func (cfg *apiConfig) uploadRendition(ctx context.Context, bucket string, userID uuid.UUID, quality, filePath string, newKey func() (string, error), metadata map[string]string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return cfg.uploadNewObjectToS3(ctx, bucket, func() (string, error) {
+		key, err := newKey()
+		if err != nil {
+			return "", err
+		}
+		return cfg.userKey(userID, path.Join(quality, key)), nil
+	}, file, "video/mp4", metadata)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudget caps the retries of every step in one upload together, so a
+// request that keeps failing doesn't retry each step to its own limit.
+type retryBudget struct {
+	mu       sync.Mutex
+	retries  int
+	deadline time.Time
+}
+
+type retryBudgetKey struct{}
+
+func (cfg *apiConfig) withRetryBudget(ctx context.Context) context.Context {
+	if cfg.retryBudgetAttempts <= 0 && cfg.retryBudgetDuration <= 0 {
+		return ctx
+	}
+	budget := &retryBudget{retries: -1}
+	if cfg.retryBudgetAttempts > 0 {
+		budget.retries = cfg.retryBudgetAttempts
+	}
+	if cfg.retryBudgetDuration > 0 {
+		budget.deadline = time.Now().Add(cfg.retryBudgetDuration)
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// spendRetry takes one retry from the request's budget. Requests without a
+// budget can always retry.
+func spendRetry(ctx context.Context) error {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return nil
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if !budget.deadline.IsZero() && time.Now().After(budget.deadline) {
+		return errRetryBudgetExhausted
+	}
+	if budget.retries == 0 {
+		return errRetryBudgetExhausted
+	}
+	if budget.retries > 0 {
+		budget.retries--
+	}
+	return nil
+}
+
+func pipelineErrorStatus(err error) int {
+	if errors.Is(err, errRetryBudgetExhausted) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const maxKeyAttempts = 3
+
+const (
+	maxConfirmAttempts = 5
+	confirmRetryDelay  = 200 * time.Millisecond
+)
+
+func newS3HTTPClient(maxIdleConns, maxConnsPerHost int) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.MaxIdleConns = maxIdleConns
+		tr.MaxConnsPerHost = maxConnsPerHost
+	})
+}
+
+func validateObjectLock(ctx context.Context, client *s3.Client, bucket string) error {
+	output, err := client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("could not get object lock configuration for bucket %s: %w", bucket, err)
+	}
+	if output.ObjectLockConfiguration == nil || output.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("object lock is not enabled on bucket %s", bucket)
+	}
+	return nil
+}
+
+// requestPayer is set on every object request so requester-pays buckets
+// accept them.
+func (cfg *apiConfig) requestPayer() types.RequestPayer {
+	if cfg.requesterPays {
+		return types.RequestPayerRequester
+	}
+	return ""
+}
+
+func (cfg *apiConfig) applyPutOptions(input *s3.PutObjectInput) {
+	input.RequestPayer = cfg.requestPayer()
+	if cfg.s3KMSKeyID != "" {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(cfg.s3KMSKeyID)
+	}
+	if cfg.s3ObjectLockMode != "" {
+		input.ObjectLockMode = types.ObjectLockMode(cfg.s3ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().UTC().Add(cfg.s3ObjectLockRetention))
+	}
+}
+
+func (cfg *apiConfig) putObject(ctx context.Context, input *s3.PutObjectInput) error {
+	if cfg.devMode {
+		return cfg.writeLocalObject(input)
+	}
+	cfg.applyPutOptions(input)
+	if cfg.multipartThreshold > 0 && aws.ToInt64(input.ContentLength) > cfg.multipartThreshold {
+		// The uploader copies IfNoneMatch onto CompleteMultipartUpload, so
+		// large objects keep the same conditional-write semantics.
+		uploader := manager.NewUploader(cfg.s3Client, func(u *manager.Uploader) {
+			u.PartSize = cfg.s3PartSize
+			u.Concurrency = cfg.s3UploadConcurrency
+		})
+		_, err := uploader.Upload(ctx, input)
+		return err
+	}
+	_, err := cfg.s3Client.PutObject(ctx, input)
+	return err
+}
+
+func (cfg *apiConfig) writeLocalObject(input *s3.PutObjectInput) error {
+	diskPath := cfg.getAssetDiskPath(aws.ToString(input.Key))
+	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if aws.ToString(input.IfNoneMatch) == "*" {
+		flags |= os.O_EXCL
+	}
+	dst, err := os.OpenFile(diskPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, input.Body)
+	return err
+}
+
+func (cfg *apiConfig) deleteObject(ctx context.Context, bucket, key string) error {
+	if cfg.devMode {
+		err := os.Remove(cfg.getAssetDiskPath(key))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: cfg.requestPayer(),
+	})
+	return err
+}
+
+func (cfg *apiConfig) uploadToS3(ctx context.Context, bucket, key string, body io.Reader, contentType string) error {
+	return cfg.putObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+}
+
+func (cfg *apiConfig) uploadNewObjectToS3(ctx context.Context, bucket string, newKey func() (string, error), body io.ReadSeeker, contentType string, metadata map[string]string) (string, error) {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("could not size upload body: %w", err)
+	}
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("could not rewind upload body: %w", err)
+		}
+
+		key, err := newKey()
+		if err != nil {
+			return "", err
+		}
+		err = cfg.putObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			Body:          body,
+			ContentLength: aws.Int64(size),
+			ContentType:   aws.String(contentType),
+			IfNoneMatch:   aws.String("*"),
+			Metadata:      metadata,
+		})
+		if err == nil {
+			return key, nil
+		}
+		if !isPreconditionFailed(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free object key after %d attempts", maxKeyAttempts)
+}
+
+func (cfg *apiConfig) confirmObject(ctx context.Context, bucket, key string) error {
+	if !cfg.confirmUploads || cfg.devMode {
+		return nil
+	}
+	for attempt := 1; attempt <= maxConfirmAttempts; attempt++ {
+		_, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			RequestPayer: cfg.requestPayer(),
+		})
+		if err == nil {
+			return nil
+		}
+		if !isNotFound(err) {
+			return err
+		}
+		if attempt < maxConfirmAttempts {
+			if budgetErr := spendRetry(ctx); budgetErr != nil {
+				return fmt.Errorf("%w: %w", budgetErr, err)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * confirmRetryDelay):
+			}
+		}
+	}
+	return fmt.Errorf("object %s not visible after %d attempts", key, maxConfirmAttempts)
+}
+
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey")
+}
+
+func isPreconditionFailed(err error) bool {
+	if errors.Is(err, os.ErrExist) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const postPolicyAlgorithm = "AWS4-HMAC-SHA256"
+
+type postPolicy struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (cfg *apiConfig) presignPostPolicy(ctx context.Context, bucket, key, keyPrefix, contentType string, maxBytes int64, expiresIn time.Duration) (postPolicy, error) {
+	creds, err := cfg.s3Client.Options().Credentials.Retrieve(ctx)
+	if err != nil {
+		return postPolicy{}, fmt.Errorf("could not retrieve AWS credentials: %w", err)
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", creds.AccessKeyID, date, cfg.s3Region)
+
+	conditions := []any{
+		map[string]string{"bucket": bucket},
+		[]any{"starts-with", "$key", keyPrefix},
+		[]any{"eq", "$Content-Type", contentType},
+		[]any{"content-length-range", 0, maxBytes},
+		map[string]string{"x-amz-algorithm": postPolicyAlgorithm},
+		map[string]string{"x-amz-credential": credential},
+		map[string]string{"x-amz-date": amzDate},
+	}
+	fields := map[string]string{
+		"key":              key,
+		"Content-Type":     contentType,
+		"x-amz-algorithm":  postPolicyAlgorithm,
+		"x-amz-credential": credential,
+		"x-amz-date":       amzDate,
+	}
+	if creds.SessionToken != "" {
+		conditions = append(conditions, map[string]string{"x-amz-security-token": creds.SessionToken})
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+
+	policyJSON, err := json.Marshal(map[string]any{
+		"expiration": now.Add(expiresIn).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return postPolicy{}, err
+	}
+	encodedPolicy := base64.StdEncoding.EncodeToString(policyJSON)
+
+	signingKey := sigV4SigningKey(creds.SecretAccessKey, date, cfg.s3Region, "s3")
+	fields["policy"] = encodedPolicy
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, encodedPolicy))
+
+	return postPolicy{
+		URL:    cfg.s3ObjectURL(bucket, ""),
+		Fields: fields,
+	}, nil
+}
+
+// sigV4SigningKey derives the Signature Version 4 key for one day, region
+// and service.
+func sigV4SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
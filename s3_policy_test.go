@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestPresignPostPolicyURL(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	})
+	tests := []struct {
+		name string
+		cfg  *apiConfig
+		want string
+	}{
+		{
+			name: "aws",
+			cfg:  &apiConfig{s3Client: client, s3Region: "us-east-1"},
+			want: "https://other-bucket.s3.us-east-1.amazonaws.com/",
+		},
+		{
+			name: "path-style endpoint",
+			cfg:  &apiConfig{s3Client: client, s3Region: "us-east-1", s3Endpoint: "http://localhost:9000", s3UsePathStyle: true},
+			want: "http://localhost:9000/other-bucket/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.s3Bucket = "default-bucket"
+			policy, err := tt.cfg.presignPostPolicy(context.Background(), "other-bucket", "uploads/key.mp4", "uploads/", "video/mp4", 1024, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.URL != tt.want {
+				t.Errorf("URL = %q, want %q", policy.URL, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigV4SigningKey(t *testing.T) {
+	// The key derivation example from the AWS Signature Version 4 docs.
+	got := hex.EncodeToString(sigV4SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam"))
+	if want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"; got != want {
+		t.Errorf("signing key = %s, want %s", got, want)
+	}
+}
+
+func TestPresignPostPolicyConditions(t *testing.T) {
+	const secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	cfg := &apiConfig{
+		s3Region: "us-west-2",
+		s3Client: s3.New(s3.Options{
+			Region:      "us-west-2",
+			Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", secret, ""),
+		}),
+	}
+	before := time.Now().UTC()
+	policy, err := cfg.presignPostPolicy(context.Background(), "tubely-test", "t-1/uploads/key.mp4", "t-1/uploads/", "video/mp4", 1<<20, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policyJSON, err := base64.StdEncoding.DecodeString(policy.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Expiration string `json:"expiration"`
+		Conditions []any  `json:"conditions"`
+	}
+	if err := json.Unmarshal(policyJSON, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	amzDate := policy.Fields["x-amz-date"]
+	credential := "AKIDEXAMPLE/" + amzDate[:8] + "/us-west-2/s3/aws4_request"
+	wantConditions := []any{
+		map[string]any{"bucket": "tubely-test"},
+		[]any{"starts-with", "$key", "t-1/uploads/"},
+		[]any{"eq", "$Content-Type", "video/mp4"},
+		[]any{"content-length-range", float64(0), float64(1 << 20)},
+		map[string]any{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+		map[string]any{"x-amz-credential": credential},
+		map[string]any{"x-amz-date": amzDate},
+	}
+	if !reflect.DeepEqual(decoded.Conditions, wantConditions) {
+		t.Errorf("conditions = %v, want %v", decoded.Conditions, wantConditions)
+	}
+
+	expiration, err := time.Parse("2006-01-02T15:04:05.000Z", decoded.Expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := expiration.Sub(before); wait < 9*time.Minute || wait > 11*time.Minute {
+		t.Errorf("policy expires in %v, want about 10m", wait)
+	}
+
+	wantFields := map[string]string{
+		"key":              "t-1/uploads/key.mp4",
+		"Content-Type":     "video/mp4",
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credential,
+	}
+	for name, want := range wantFields {
+		if got := policy.Fields[name]; got != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+
+	signingKey := sigV4SigningKey(secret, amzDate[:8], "us-west-2", "s3")
+	if want := hex.EncodeToString(hmacSHA256(signingKey, policy.Fields["policy"])); policy.Fields["x-amz-signature"] != want {
+		t.Errorf("signature = %s, want %s", policy.Fields["x-amz-signature"], want)
+	}
+}
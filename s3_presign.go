@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func generatePresignedURL(ctx context.Context, s3Client *s3.Client, bucket, key string, expireTime time.Duration, requestPayer types.RequestPayer) (string, error) {
+	presignClient := s3.NewPresignClient(s3Client)
+	req, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: requestPayer,
+	}, s3.WithPresignExpires(expireTime))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (cfg *apiConfig) storedVideoURL(bucket, key string) string {
+	if cfg.presignVideoURLs || bucket != cfg.s3Bucket {
+		return bucket + "," + key
+	}
+	return cfg.getObjectURL(key)
+}
+
+// storedAssetURL is how thumbnails and contact sheets are stored: a public
+// URL in the default bucket, or bucket,key to be signed when handed out.
+func (cfg *apiConfig) storedAssetURL(bucket, key string) string {
+	if bucket != cfg.s3Bucket {
+		return bucket + "," + key
+	}
+	return cfg.getObjectURL(key)
+}
+
+func (cfg *apiConfig) signStoredURL(ctx context.Context, storedURL string) (string, error) {
+	bucket, key, ok := strings.Cut(storedURL, ",")
+	if !ok {
+		return storedURL, nil
+	}
+	if cfg.devMode {
+		return cfg.getAssetURL(key), nil
+	}
+	return generatePresignedURL(ctx, cfg.s3Client, bucket, key, cfg.presignExpiry, cfg.requestPayer())
+}
+
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.VideoURL != nil {
+		signedURL, err := cfg.signStoredURL(ctx, *video.VideoURL)
+		if err != nil {
+			return database.Video{}, err
+		}
+		signedURL = cfg.httpsURL(signedURL)
+		video.VideoURL = &signedURL
+	}
+
+	if video.Renditions != nil {
+		signed := make(database.Renditions, len(video.Renditions))
+		for quality, storedURL := range video.Renditions {
+			signedURL, err := cfg.signStoredURL(ctx, storedURL)
+			if err != nil {
+				return database.Video{}, err
+			}
+			signed[quality] = cfg.httpsURL(signedURL)
+		}
+		video.Renditions = signed
+	}
+
+	for _, assetURL := range []**string{&video.ThumbnailURL, &video.PreviousThumbnailURL, &video.ContactSheetURL} {
+		if *assetURL == nil {
+			continue
+		}
+		signedURL, err := cfg.signStoredURL(ctx, **assetURL)
+		if err != nil {
+			return database.Video{}, err
+		}
+		signedURL = cfg.httpsURL(signedURL)
+		*assetURL = &signedURL
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) dbVideosToSignedVideos(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	for i, video := range videos {
+		signed, err := cfg.dbVideoToSignedVideo(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		videos[i] = signed
+	}
+	return videos, nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// fakeS3 is just enough of the S3 API for putObject, confirmObject and
+// deleteObject: single PUTs, which honor If-None-Match against existing,
+// multipart uploads, HEADs and DELETEs. It records what it was sent.
+type fakeS3 struct {
+	mu       sync.Mutex
+	existing map[string]bool
+	// strictDeletes answers DELETEs of missing keys with NoSuchKey, as some
+	// S3-compatible stores do, instead of S3's 204.
+	strictDeletes bool
+	// invisible makes HEADs miss every key, like a store whose writes
+	// never become visible.
+	invisible       bool
+	puts            []string
+	putHeaders      map[string]http.Header
+	heads           int
+	deletes         []string
+	parts           int
+	completeHeaders []http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%s"`, query.Get("partNumber")))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeHeaders = append(f.completeHeaders, r.Header.Clone())
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodPut:
+		f.puts = append(f.puts, r.URL.Path)
+		if f.putHeaders != nil {
+			f.putHeaders[r.URL.Path] = r.Header.Clone()
+		}
+		if f.existing[r.URL.Path] && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+		f.existing[r.URL.Path] = true
+	case r.Method == http.MethodHead:
+		f.heads++
+		if f.invisible || !f.existing[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodDelete:
+		f.deletes = append(f.deletes, r.URL.Path)
+		if f.strictDeletes && !f.existing[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		delete(f.existing, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+// newFakeS3Config returns a non-dev config whose client talks to handler.
+func newFakeS3Config(t *testing.T, handler http.Handler) *apiConfig {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(t)
+	cfg.devMode = false
+	cfg.s3Bucket = "tubely-test"
+	cfg.s3Region = "us-east-1"
+	cfg.s3Client = s3.New(s3.Options{
+		Region:       cfg.s3Region,
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	return cfg
+}
+
+func TestUploadNewObjectUsesMultipartAboveThreshold(t *testing.T) {
+	const partSize = 5 << 20
+
+	tests := []struct {
+		name      string
+		size      int
+		wantParts int
+		wantPuts  int
+	}{
+		{"below threshold", partSize, 0, 1},
+		{"above threshold", 2*partSize + 1, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{}}
+			cfg := newFakeS3Config(t, fake)
+			cfg.multipartThreshold = partSize
+			cfg.s3PartSize = partSize
+			cfg.s3UploadConcurrency = 2
+
+			newKey := func() (string, error) { return "videos/a.mp4", nil }
+			body := bytes.NewReader(make([]byte, tt.size))
+			if _, err := cfg.uploadNewObjectToS3(context.Background(), cfg.s3Bucket, newKey, body, "video/mp4", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if fake.parts != tt.wantParts || len(fake.puts) != tt.wantPuts {
+				t.Errorf("got %d parts and %d puts, want %d and %d", fake.parts, len(fake.puts), tt.wantParts, tt.wantPuts)
+			}
+			if tt.wantParts > 0 && len(fake.completeHeaders) != 1 {
+				t.Fatalf("got %d CompleteMultipartUpload calls, want 1", len(fake.completeHeaders))
+			}
+			for _, header := range fake.completeHeaders {
+				if got := header.Get("If-None-Match"); got != "*" {
+					t.Errorf("CompleteMultipartUpload If-None-Match = %q, want *", got)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadNewObjectRegeneratesTakenKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		devMode bool
+	}{
+		{"s3", false},
+		{"local", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{"/tubely-test/videos/taken.mp4": true}}
+			cfg := newFakeS3Config(t, fake)
+			cfg.devMode = tt.devMode
+			if tt.devMode {
+				if err := os.MkdirAll(filepath.Join(cfg.assetsRoot, "videos"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(cfg.assetsRoot, "videos", "taken.mp4"), []byte("original"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			keys := []string{"videos/taken.mp4", "videos/free.mp4"}
+			attempts := 0
+			newKey := func() (string, error) {
+				key := keys[min(attempts, len(keys)-1)]
+				attempts++
+				return key, nil
+			}
+			key, err := cfg.uploadNewObjectToS3(context.Background(), cfg.s3Bucket, newKey, strings.NewReader("video"), "video/mp4", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != "videos/free.mp4" || attempts != 2 {
+				t.Errorf("got key %q after %d attempts, want videos/free.mp4 after 2", key, attempts)
+			}
+
+			if tt.devMode {
+				data, err := os.ReadFile(filepath.Join(cfg.assetsRoot, "videos", "taken.mp4"))
+				if err != nil || string(data) != "original" {
+					t.Errorf("existing object was overwritten: %q, %v", data, err)
+				}
+				return
+			}
+			want := []string{"/tubely-test/videos/taken.mp4", "/tubely-test/videos/free.mp4"}
+			if !slices.Equal(fake.puts, want) {
+				t.Errorf("puts = %v, want %v", fake.puts, want)
+			}
+		})
+	}
+}
+
+func TestVideoUploadUnconfirmedObject(t *testing.T) {
+	installFakeFFmpeg(t)
+
+	tests := []struct {
+		name          string
+		retryAttempts int
+		wantStatus    int
+		wantHeads     int
+	}{
+		{"every attempt misses", 0, http.StatusInternalServerError, maxConfirmAttempts},
+		{"retry budget runs out", 1, http.StatusServiceUnavailable, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{existing: map[string]bool{}, invisible: true}
+			cfg := newFakeS3Config(t, fake)
+			cfg.confirmUploads = true
+			cfg.retryBudgetAttempts = tt.retryAttempts
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, w.Code, w.Body)
+			}
+			if fake.heads != tt.wantHeads {
+				t.Errorf("got %d HEADs, want %d", fake.heads, tt.wantHeads)
+			}
+			if len(fake.puts) != 1 || !slices.Equal(fake.deletes, fake.puts) {
+				t.Errorf("unconfirmed object wasn't cleaned up: puts %v, deletes %v", fake.puts, fake.deletes)
+			}
+
+			updated, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.VideoURL != nil {
+				t.Errorf("unconfirmed video was saved: %s", *updated.VideoURL)
+			}
+			if updated.ProcessingStatus != database.ProcessingStatusFailed {
+				t.Errorf("processing status = %q, want %q", updated.ProcessingStatus, database.ProcessingStatusFailed)
+			}
+		})
+	}
+}
+
+func TestNewS3HTTPClientLimits(t *testing.T) {
+	tests := []struct {
+		maxIdleConns    int
+		maxConnsPerHost int
+	}{
+		{100, 0},
+		{16, 8},
+	}
+	for _, tt := range tests {
+		transport := newS3HTTPClient(tt.maxIdleConns, tt.maxConnsPerHost).GetTransport()
+		if transport.MaxIdleConns != tt.maxIdleConns || transport.MaxConnsPerHost != tt.maxConnsPerHost {
+			t.Errorf("transport has MaxIdleConns %d and MaxConnsPerHost %d, want %d and %d",
+				transport.MaxIdleConns, transport.MaxConnsPerHost, tt.maxIdleConns, tt.maxConnsPerHost)
+		}
+	}
+}
+
+func TestTenantIsolationScopesObjects(t *testing.T) {
+	installFakeFFmpeg(t)
+	fake := &fakeS3{existing: map[string]bool{}}
+	cfg := newFakeS3Config(t, fake)
+	cfg.tenantIsolation = true
+	cfg.presignVideoURLs = true
+	cfg.presignExpiry = time.Minute
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		userID, token := createTestUser(t, cfg, email)
+		video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+		prefix := "/tubely-test/" + tenantSegment(userID) + "/"
+		fake.puts, fake.deletes = nil, nil
+
+		w := uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", email, w.Code, w.Body)
+		}
+		if len(fake.puts) == 0 {
+			t.Fatalf("%s: nothing was stored", email)
+		}
+		for _, put := range fake.puts {
+			if !strings.HasPrefix(put, prefix) {
+				t.Errorf("%s: stored %s outside %s", email, put, prefix)
+			}
+		}
+
+		var signed database.Video
+		if err := json.Unmarshal(w.Body.Bytes(), &signed); err != nil {
+			t.Fatal(err)
+		}
+		signedURL, err := url.Parse(*signed.VideoURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(signedURL.Path, prefix) || !signedURL.Query().Has("X-Amz-Signature") {
+			t.Errorf("%s: presigned URL %s isn't a signed URL under %s", email, *signed.VideoURL, prefix)
+		}
+
+		if w := deleteVideo(cfg, video.ID, token); w.Code != http.StatusNoContent {
+			t.Fatalf("%s: delete: expected 204, got %d: %s", email, w.Code, w.Body)
+		}
+		if len(fake.deletes) == 0 {
+			t.Errorf("%s: nothing was deleted", email)
+		}
+		for _, deleted := range fake.deletes {
+			if !strings.HasPrefix(deleted, prefix) {
+				t.Errorf("%s: deleted %s outside %s", email, deleted, prefix)
+			}
+		}
+	}
+	if len(fake.existing) != 0 {
+		t.Errorf("objects left behind: %v", fake.existing)
+	}
+}
+
+func TestIncludeKey(t *testing.T) {
+	installFakeFFmpeg(t)
+	fake := &fakeS3{existing: map[string]bool{}}
+	cfg := newFakeS3Config(t, fake)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	responseKey := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Key *string `json:"key"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Key == nil {
+			return ""
+		}
+		return *resp.Key
+	}
+
+	if key := responseKey(t, uploadVideo(t, cfg, video.ID, token, "", testMP4(1000))); key != "" {
+		t.Errorf("upload without includeKey returned key %q", key)
+	}
+	fake.puts = nil
+	key := responseKey(t, uploadVideo(t, cfg, video.ID, token, "?includeKey=true", testMP4(1000)))
+	if key == "" || !slices.Contains(fake.puts, "/tubely-test/"+key) {
+		t.Fatalf("upload returned key %q, want one of the stored objects %v", key, fake.puts)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		token string
+		want  string
+	}{
+		{"owner requesting the key", "?includeKey=true", token, key},
+		{"owner without the flag", "", token, ""},
+		{"other user", "?includeKey=true", otherToken, ""},
+		{"anonymous", "?includeKey=true", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := responseKey(t, getVideoMeta(cfg, current, tt.query, tt.token, "")); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoUploadPassesObjectMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		want     int
+		wantMeta map[string]string
+	}{
+		{
+			name:    "client and server metadata",
+			headers: map[string]string{"X-Amz-Meta-Camera": "Pixel 8"},
+			want:    http.StatusOK,
+			wantMeta: map[string]string{
+				"X-Amz-Meta-Camera":            "Pixel 8",
+				"X-Amz-Meta-Original-Filename": "video.mp4",
+			},
+		},
+		{
+			name:    "client can't override the owner",
+			headers: map[string]string{"X-Amz-Meta-User-Id": "someone-else"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "over the size limit",
+			headers: map[string]string{"X-Amz-Meta-Notes": strings.Repeat("x", maxObjectMetadataBytes)},
+			want:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeFFmpeg(t)
+			fake := &fakeS3{existing: map[string]bool{}, putHeaders: map[string]http.Header{}}
+			cfg := newFakeS3Config(t, fake)
+			userID, token := createTestUser(t, cfg, "user@example.com")
+			video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+			req := newVideoUploadRequest(t, video.ID, token, "?includeKey=true", testMP4(1000))
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			cfg.handlerUploadVideo(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				if len(fake.puts) != 0 {
+					t.Errorf("objects were stored: %v", fake.puts)
+				}
+				return
+			}
+
+			var resp struct {
+				Key string `json:"key"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			header, ok := fake.putHeaders["/tubely-test/"+resp.Key]
+			if !ok {
+				t.Fatalf("video %s wasn't stored; puts = %v", resp.Key, fake.puts)
+			}
+			if got := header.Get("X-Amz-Meta-User-Id"); got != userID.String() {
+				t.Errorf("user-id metadata = %q, want %q", got, userID)
+			}
+			for name, want := range tt.wantMeta {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const storageBucketHeader = "X-Storage-Bucket"
+
+func (cfg *apiConfig) requestBucket(r *http.Request) (string, error) {
+	bucket := r.Header.Get(storageBucketHeader)
+	if !cfg.trustBucketHeader || bucket == "" {
+		return cfg.s3Bucket, nil
+	}
+	if !slices.Contains(cfg.bucketAllowlist, bucket) {
+		return "", fmt.Errorf("bucket %q is not in the allowlist", bucket)
+	}
+	return bucket, nil
+}
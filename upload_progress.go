@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	uploadProgressPollInterval = 500 * time.Millisecond
+	uploadProgressRetention    = 30 * time.Second
+)
+
+type uploadProgressEntry struct {
+	bytes     atomic.Int64
+	total     int64
+	succeeded atomic.Bool
+	done      atomic.Bool
+}
+
+type uploadProgressTracker struct {
+	mu      sync.Mutex
+	uploads map[uuid.UUID]*uploadProgressEntry
+}
+
+func newUploadProgressTracker() *uploadProgressTracker {
+	return &uploadProgressTracker{
+		uploads: make(map[uuid.UUID]*uploadProgressEntry),
+	}
+}
+
+func (t *uploadProgressTracker) start(videoID uuid.UUID, total int64) *uploadProgressEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := &uploadProgressEntry{total: total}
+	t.uploads[videoID] = entry
+	return entry
+}
+
+func (t *uploadProgressTracker) get(videoID uuid.UUID) *uploadProgressEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.uploads[videoID]
+}
+
+// finish keeps the entry around briefly so late subscribers still see the
+// terminal state.
+func (t *uploadProgressTracker) finish(videoID uuid.UUID, entry *uploadProgressEntry) {
+	entry.done.Store(true)
+	time.AfterFunc(uploadProgressRetention, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.uploads[videoID] == entry {
+			delete(t.uploads, videoID)
+		}
+	})
+}
+
+type progressReader struct {
+	io.ReadCloser
+	entry *uploadProgressEntry
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	n, err := pr.ReadCloser.Read(p)
+	pr.entry.bytes.Add(int64(n))
+	return n, err
+}
+
+func (cfg *apiConfig) handlerUploadProgress(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ticker := time.NewTicker(uploadProgressPollInterval)
+	defer ticker.Stop()
+	lastBytes := int64(-1)
+	for {
+		if entry := cfg.uploadProgress.get(videoID); entry != nil {
+			done := entry.done.Load()
+			bytes := entry.bytes.Load()
+			if bytes != lastBytes || done {
+				lastBytes = bytes
+				event := "progress"
+				if done && entry.succeeded.Load() {
+					event = "complete"
+				} else if done {
+					event = "error"
+				}
+				fmt.Fprintf(w, "event: %s\ndata: {\"bytes\":%d,\"total\":%d}\n\n", event, bytes, entry.total)
+				if err := rc.Flush(); err != nil || done {
+					return
+				}
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
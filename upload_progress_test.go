@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type progressEvent struct {
+	name  string
+	Bytes int64 `json:"bytes"`
+	Total int64 `json:"total"`
+}
+
+func TestUploadProgressEvents(t *testing.T) {
+	installFakeFFmpeg(t)
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "user@example.com")
+	video := createTestVideo(t, cfg, userID, database.VisibilityPublic)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}/upload-progress", cfg.handlerUploadProgress)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	// The upload body is fed in chunks, each only once the previous one has
+	// shown up as a progress event.
+	req := newVideoUploadRequest(t, video.ID, token, "", testMP4(64<<10))
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyReader, bodyWriter := io.Pipe()
+	req.Body = bodyReader
+	req.ContentLength = int64(len(body))
+
+	uploaded := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		cfg.handlerUploadVideo(w, req)
+		uploaded <- w
+	}()
+
+	observed := make(chan int64, 100)
+	go func() {
+		const chunks = 3
+		var sent int64
+		for i := 0; i < chunks; i++ {
+			chunk := body[int(sent) : (i+1)*len(body)/chunks]
+			if _, err := bodyWriter.Write(chunk); err != nil {
+				bodyWriter.CloseWithError(err)
+				return
+			}
+			sent += int64(len(chunk))
+			if i == chunks-1 {
+				break
+			}
+			for seen := range observed {
+				if seen >= sent {
+					break
+				}
+			}
+		}
+		bodyWriter.Close()
+	}()
+
+	progressReq, err := http.NewRequest(http.MethodGet, server.URL+"/api/videos/"+video.ID.String()+"/upload-progress", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progressReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(progressReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var events []progressEvent
+	scanner := bufio.NewScanner(resp.Body)
+	var name string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "event: "); ok {
+			name = after
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		event := progressEvent{name: name}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+		observed <- event.Bytes
+	}
+	close(observed)
+
+	select {
+	case w := <-uploaded:
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload: expected 200, got %d: %s", w.Code, w.Body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload didn't finish")
+	}
+
+	if len(events) < 3 {
+		t.Fatalf("got %d events, want one per chunk and the final one: %+v", len(events), events)
+	}
+	for i, event := range events {
+		if event.Total != req.ContentLength {
+			t.Errorf("event %d: total = %d, want %d", i, event.Total, req.ContentLength)
+		}
+		if i > 0 && event.Bytes <= events[i-1].Bytes && event.name == "progress" {
+			t.Errorf("event %d: bytes went from %d to %d", i, events[i-1].Bytes, event.Bytes)
+		}
+		if last := i == len(events)-1; last != (event.name != "progress") {
+			t.Errorf("event %d is %q", i, event.name)
+		}
+	}
+	if last := events[len(events)-1]; last.name != "complete" || last.Bytes != req.ContentLength {
+		t.Errorf("last event = %+v, want complete with all %d bytes", last, req.ContentLength)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+func (cfg *apiConfig) uploadTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadTimeout == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deadline := time.Now().Add(cfg.uploadTimeout)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		// The context alone can't interrupt a blocked body read, so also
+		// bound reads on the underlying connection.
+		err := http.NewResponseController(w).SetReadDeadline(deadline)
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't set upload deadline", err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func uploadTimedOut(r *http.Request, err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
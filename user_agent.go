@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (cfg *apiConfig) userAgentFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent := r.UserAgent()
+		if cfg.requireUserAgent && userAgent == "" {
+			respondWithError(w, http.StatusForbidden, "User-Agent header is required", nil)
+			return
+		}
+		lower := strings.ToLower(userAgent)
+		for _, blocked := range cfg.userAgentBlocklist {
+			if strings.Contains(lower, strings.ToLower(blocked)) {
+				respondWithError(w, http.StatusForbidden, "User-Agent is not allowed", nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserAgentFilterMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		require   bool
+		blocklist []string
+		userAgent string
+		want      int
+	}{
+		{"missing, not required", false, nil, "", http.StatusOK},
+		{"missing, required", true, nil, "", http.StatusForbidden},
+		{"present, required", true, nil, "Mozilla/5.0", http.StatusOK},
+		{"blocked", false, []string{"curl"}, "curl/8.4.0", http.StatusForbidden},
+		{"blocked ignoring case", false, []string{"python-requests"}, "Python-Requests/2.31", http.StatusForbidden},
+		{"blocked as a substring", false, []string{"bot"}, "Mozilla/5.0 (compatible; FooBot/1.0)", http.StatusForbidden},
+		{"not on the blocklist", false, []string{"curl", "wget"}, "Mozilla/5.0", http.StatusOK},
+		{"missing with only a blocklist", false, []string{"curl"}, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{requireUserAgent: tt.require, userAgentBlocklist: tt.blocklist}
+			handler := cfg.userAgentFilterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", nil)
+			req.Header.Del("User-Agent")
+			if tt.userAgent != "" {
+				req.Header.Set("User-Agent", tt.userAgent)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}